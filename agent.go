@@ -0,0 +1,545 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// Default time the agent stays unlocked without requests
+	defaultAgentTimeout = 15 * time.Minute
+
+	// Maximum time for a single agent request
+	agentRequestTimeout = 30 * time.Second
+
+	// Set in the environment of the background agent process, which
+	// inherits the listening socket as fd 3 and the vault key on fd 4
+	agentDaemonEnv = "CAWS_AGENT_DAEMON"
+)
+
+// agentRequest is a CredentialStore operation sent to the agent
+type agentRequest struct {
+	Op         string    `json:"op"` // "get", "create", "list", "remove", "set-mfa-seed", "get-sso-token", "store-sso-token" or "stop"
+	Profile    string    `json:"profile,omitempty"`
+	AccessKey  string    `json:"access_key,omitempty"`
+	SecretKey  string    `json:"secret_key,omitempty"`
+	MFASeed    string    `json:"mfa_seed,omitempty"`
+	SSOSession string    `json:"sso_session,omitempty"`
+	SSOToken   *SSOToken `json:"sso_token,omitempty"`
+}
+
+// agentResponse is the agent's answer to an agentRequest
+type agentResponse struct {
+	Error       string          `json:"error,omitempty"`
+	Credentials *AWSCredentials `json:"credentials,omitempty"`
+	Profiles    []ProfileInfo   `json:"profiles,omitempty"`
+	SSOToken    *SSOToken       `json:"sso_token,omitempty"`
+}
+
+// AgentClient is a CredentialStore backed by a running caws agent
+type AgentClient struct {
+	socketPath string
+}
+
+// Ensure AgentClient implements CredentialStore
+var _ CredentialStore = (*AgentClient)(nil)
+
+// NewAgentClient connects to the agent listening on socketPath
+func NewAgentClient(socketPath string) (*AgentClient, error) {
+	conn, err := net.DialTimeout("unix", socketPath, time.Second)
+	if err != nil {
+		return nil, err
+	}
+	conn.Close()
+
+	return &AgentClient{socketPath: socketPath}, nil
+}
+
+// openCredentialStore uses the caws agent when CAWS_AGENT_SOCK is set,
+// and otherwise opens the vault directly (prompting for the password)
+func openCredentialStore() (CredentialStore, error) {
+	if socketPath := os.Getenv("CAWS_AGENT_SOCK"); socketPath != "" {
+		client, err := NewAgentClient(socketPath)
+		if err == nil {
+			return client, nil
+		}
+		fmt.Fprintf(os.Stderr, "Warning: caws agent not reachable (%v), opening vault directly\n", err)
+	}
+
+	return NewVaultClient()
+}
+
+// call sends a single request to the agent
+func (a *AgentClient) call(req agentRequest) (*agentResponse, error) {
+	conn, err := net.DialTimeout("unix", a.socketPath, time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to caws agent: %w", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(agentRequestTimeout))
+
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return nil, fmt.Errorf("failed to send request to caws agent: %w", err)
+	}
+
+	var resp agentResponse
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return nil, fmt.Errorf("failed to read response from caws agent: %w", err)
+	}
+
+	if resp.Error != "" {
+		return nil, errors.New(resp.Error)
+	}
+
+	return &resp, nil
+}
+
+// GetCredentials implements the CredentialStore interface
+func (a *AgentClient) GetCredentials(profile string) (*AWSCredentials, error) {
+	resp, err := a.call(agentRequest{Op: "get", Profile: profile})
+	if err != nil {
+		return nil, err
+	}
+	if resp.Credentials == nil {
+		return nil, fmt.Errorf("caws agent returned no credentials")
+	}
+	return resp.Credentials, nil
+}
+
+// CreateCredentials implements the CredentialStore interface
+func (a *AgentClient) CreateCredentials(profile, accessKey, secretKey string) error {
+	_, err := a.call(agentRequest{Op: "create", Profile: profile, AccessKey: accessKey, SecretKey: secretKey})
+	return err
+}
+
+// ListProfiles implements the CredentialStore interface
+func (a *AgentClient) ListProfiles() ([]ProfileInfo, error) {
+	resp, err := a.call(agentRequest{Op: "list"})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Profiles, nil
+}
+
+// RemoveProfile implements the CredentialStore interface
+func (a *AgentClient) RemoveProfile(profile string) error {
+	_, err := a.call(agentRequest{Op: "remove", Profile: profile})
+	return err
+}
+
+// SetMFASeed implements the CredentialStore interface
+func (a *AgentClient) SetMFASeed(profile, seed string) error {
+	_, err := a.call(agentRequest{Op: "set-mfa-seed", Profile: profile, MFASeed: seed})
+	return err
+}
+
+// GetSSOToken implements the CredentialStore interface
+func (a *AgentClient) GetSSOToken(session string) (*SSOToken, error) {
+	resp, err := a.call(agentRequest{Op: "get-sso-token", SSOSession: session})
+	if err != nil {
+		return nil, err
+	}
+	return resp.SSOToken, nil
+}
+
+// StoreSSOToken implements the CredentialStore interface
+func (a *AgentClient) StoreSSOToken(session string, token *SSOToken) error {
+	_, err := a.call(agentRequest{Op: "store-sso-token", SSOSession: session, SSOToken: token})
+	return err
+}
+
+// Close implements the CredentialStore interface
+// The agent keeps the vault unlocked, so there is nothing to release
+func (a *AgentClient) Close() error {
+	return nil
+}
+
+// Stop asks the agent to forget the vault key and exit
+func (a *AgentClient) Stop() error {
+	_, err := a.call(agentRequest{Op: "stop"})
+	return err
+}
+
+// agentServer holds the unlocked vault key and serves CredentialStore
+// operations, taking the vault lock only for the duration of each request
+type agentServer struct {
+	vault      *VaultClient // unlocked, without the vault lock
+	socketPath string
+	timeout    time.Duration
+	listener   net.Listener
+
+	mu       sync.Mutex // serializes vault access
+	activity chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// serve accepts requests until the agent is stopped or times out
+func (a *agentServer) serve() error {
+	a.activity = make(chan struct{}, 1)
+	a.done = make(chan struct{})
+
+	if a.timeout > 0 {
+		go a.watchIdle()
+	}
+
+	defer a.wipe()
+
+	for {
+		conn, err := a.listener.Accept()
+		if err != nil {
+			select {
+			case <-a.done:
+				return nil
+			default:
+				a.stop()
+				return fmt.Errorf("agent socket failed: %w", err)
+			}
+		}
+		go a.handleConn(conn)
+	}
+}
+
+// watchIdle stops the agent after timeout without requests
+func (a *agentServer) watchIdle() {
+	timer := time.NewTimer(a.timeout)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-a.activity:
+			if !timer.Stop() {
+				<-timer.C
+			}
+			timer.Reset(a.timeout)
+		case <-timer.C:
+			a.stop()
+			return
+		case <-a.done:
+			return
+		}
+	}
+}
+
+// stop closes the socket, which ends serve
+func (a *agentServer) stop() {
+	a.stopOnce.Do(func() {
+		close(a.done)
+		// Unlink first: serve returns as soon as the listener is closed
+		os.Remove(a.socketPath)
+		a.listener.Close()
+	})
+}
+
+// wipe clears the vault key from memory
+func (a *agentServer) wipe() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	clearBytes(a.vault.key)
+	unix.Munlock(a.vault.key)
+}
+
+// handleConn answers a single request
+func (a *agentServer) handleConn(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(agentRequestTimeout))
+
+	var req agentRequest
+	if err := json.NewDecoder(conn).Decode(&req); err != nil {
+		return
+	}
+
+	// Record activity for the idle timeout
+	select {
+	case a.activity <- struct{}{}:
+	default:
+	}
+
+	resp := a.dispatch(req)
+	json.NewEncoder(conn).Encode(resp)
+
+	if req.Op == "stop" {
+		a.stop()
+	}
+}
+
+// dispatch performs a request against the vault
+func (a *agentServer) dispatch(req agentRequest) agentResponse {
+	switch req.Op {
+	case "stop":
+		return agentResponse{}
+	case "get", "create", "remove", "set-mfa-seed":
+		if err := validateProfileName(req.Profile); err != nil {
+			return agentResponse{Error: err.Error()}
+		}
+	case "get-sso-token", "store-sso-token":
+		if req.SSOSession == "" {
+			return agentResponse{Error: "sso_session is required"}
+		}
+		if req.Op == "store-sso-token" && req.SSOToken == nil {
+			return agentResponse{Error: "sso_token is required"}
+		}
+	case "list":
+	default:
+		return agentResponse{Error: fmt.Sprintf("unknown agent operation %q", req.Op)}
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	// Each operation takes the vault lock it needs, shared or exclusive.
+	// The client borrows the agent's key; it must not be closed (that would wipe it)
+	client := *a.vault
+
+	var resp agentResponse
+	var err error
+	switch req.Op {
+	case "get":
+		resp.Credentials, err = client.GetCredentials(req.Profile)
+	case "create":
+		err = client.CreateCredentials(req.Profile, req.AccessKey, req.SecretKey)
+	case "list":
+		resp.Profiles, err = client.ListProfiles()
+	case "remove":
+		err = client.RemoveProfile(req.Profile)
+	case "set-mfa-seed":
+		err = client.SetMFASeed(req.Profile, req.MFASeed)
+	case "get-sso-token":
+		resp.SSOToken, err = client.GetSSOToken(req.SSOSession)
+	case "store-sso-token":
+		err = client.StoreSSOToken(req.SSOSession, req.SSOToken)
+	}
+	if err != nil {
+		return agentResponse{Error: err.Error()}
+	}
+
+	return resp
+}
+
+// agentOptions holds the flags of the agent command
+type agentOptions struct {
+	timeout    time.Duration
+	foreground bool
+	stop       bool
+}
+
+// getAgentSocketPath returns the default agent socket path
+func getAgentSocketPath() string {
+	// Check for test mode
+	if testDir := os.Getenv("CAWS_TEST_DIR"); testDir != "" {
+		return filepath.Join(testDir, "agent.sock")
+	}
+
+	return filepath.Join(getXDGRuntimeDir(), "caws", "agent.sock")
+}
+
+// handleAgent handles running the caws agent
+func handleAgent(opts agentOptions) error {
+	if opts.stop {
+		socketPath := os.Getenv("CAWS_AGENT_SOCK")
+		if socketPath == "" {
+			socketPath = getAgentSocketPath()
+		}
+		client, err := NewAgentClient(socketPath)
+		if err != nil {
+			return fmt.Errorf("no caws agent running at %s", socketPath)
+		}
+		if err := client.Stop(); err != nil {
+			return err
+		}
+		fmt.Fprintln(os.Stderr, "✓ caws agent stopped")
+		return nil
+	}
+
+	if os.Getenv(agentDaemonEnv) != "" {
+		return runAgentDaemon(opts)
+	}
+
+	vaultPath := getVaultPath()
+	if _, err := os.Stat(vaultPath); os.IsNotExist(err) {
+		return fmt.Errorf("vault not found at %s\nRun 'caws init' to create a new vault", vaultPath)
+	}
+
+	socketPath := getAgentSocketPath()
+	if conn, err := net.Dial("unix", socketPath); err == nil {
+		conn.Close()
+		return fmt.Errorf("caws agent already running at %s", socketPath)
+	}
+	// Remove a stale socket left by an agent that did not exit cleanly
+	os.Remove(socketPath)
+
+	// Unlock the vault once
+	passwordBytes, err := readPasswordBytes("Enter vault password: ")
+	if err != nil {
+		return err
+	}
+	vault, err := unlockVault(vaultPath, string(passwordBytes))
+	clearBytes(passwordBytes)
+	if err != nil {
+		return fmt.Errorf("incorrect password or corrupted vault")
+	}
+
+	if err := ensureRuntimeDir(filepath.Dir(socketPath)); err != nil {
+		clearBytes(vault.key)
+		return fmt.Errorf("failed to create agent socket directory: %w", err)
+	}
+
+	// Create the socket inaccessible to others from the start
+	oldMask := syscall.Umask(0077)
+	listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: socketPath, Net: "unix"})
+	syscall.Umask(oldMask)
+	if err != nil {
+		clearBytes(vault.key)
+		return fmt.Errorf("failed to listen on %s: %w", socketPath, err)
+	}
+	if err := os.Chmod(socketPath, 0600); err != nil {
+		listener.Close()
+		clearBytes(vault.key)
+		return fmt.Errorf("failed to secure agent socket: %w", err)
+	}
+
+	if opts.foreground {
+		fmt.Printf("CAWS_AGENT_SOCK=%s; export CAWS_AGENT_SOCK;\n", socketPath)
+		fmt.Fprintf(os.Stderr, "caws agent listening on %s (idle timeout: %s)\n", socketPath, opts.timeout)
+		return runAgent(socketPath, listener, vault, opts.timeout)
+	}
+
+	pid, err := startAgentDaemon(listener, vault.key, vault.salt, opts.timeout)
+	clearBytes(vault.key)
+	if err != nil {
+		os.Remove(socketPath)
+		return err
+	}
+
+	// Shell commands for eval "$(caws agent)", like ssh-agent
+	fmt.Printf("CAWS_AGENT_SOCK=%s; export CAWS_AGENT_SOCK;\n", socketPath)
+	fmt.Printf("echo caws agent pid %d;\n", pid)
+	return nil
+}
+
+// runAgent locks the key in memory and serves requests until the agent
+// is stopped, times out or receives SIGINT/SIGTERM
+func runAgent(socketPath string, listener net.Listener, vault *VaultClient, timeout time.Duration) error {
+	// Keep the key out of swap
+	if err := unix.Mlock(vault.key); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to lock vault key in memory: %v\n", err)
+	}
+
+	server := &agentServer{
+		vault:      vault,
+		socketPath: socketPath,
+		timeout:    timeout,
+		listener:   listener,
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(signals)
+	go func() {
+		if _, ok := <-signals; ok {
+			server.stop()
+		}
+	}()
+
+	return server.serve()
+}
+
+// startAgentDaemon re-executes caws as a detached agent process, handing
+// over the listening socket and the vault key through inherited files
+func startAgentDaemon(listener *net.UnixListener, key, salt []byte, timeout time.Duration) (int, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return 0, fmt.Errorf("failed to locate caws executable: %w", err)
+	}
+
+	listenerFile, err := listener.File()
+	if err != nil {
+		return 0, fmt.Errorf("failed to pass agent socket: %w", err)
+	}
+	defer listenerFile.Close()
+
+	// The socket now belongs to the daemon
+	listener.SetUnlinkOnClose(false)
+	defer listener.Close()
+
+	keyReader, keyWriter, err := os.Pipe()
+	if err != nil {
+		return 0, fmt.Errorf("failed to pass vault key: %w", err)
+	}
+	defer keyWriter.Close()
+
+	cmd := exec.Command(executable, "agent", "--timeout", timeout.String())
+	cmd.Env = append(os.Environ(), agentDaemonEnv+"=1")
+	cmd.ExtraFiles = []*os.File{listenerFile, keyReader} // fd 3 and fd 4
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+
+	if err := cmd.Start(); err != nil {
+		keyReader.Close()
+		return 0, fmt.Errorf("failed to start caws agent: %w", err)
+	}
+	keyReader.Close()
+
+	// Salt length, salt, then key
+	handoff := append([]byte{byte(len(salt))}, salt...)
+	handoff = append(handoff, key...)
+	_, err = keyWriter.Write(handoff)
+	clearBytes(handoff)
+	if err != nil {
+		cmd.Process.Kill()
+		return 0, fmt.Errorf("failed to pass vault key: %w", err)
+	}
+
+	pid := cmd.Process.Pid
+	cmd.Process.Release()
+	return pid, nil
+}
+
+// runAgentDaemon is the body of the detached agent process
+func runAgentDaemon(opts agentOptions) error {
+	listenerFile := os.NewFile(3, "agent-listener")
+	keyFile := os.NewFile(4, "agent-key")
+	if listenerFile == nil || keyFile == nil {
+		return fmt.Errorf("%s is set but agent files were not passed", agentDaemonEnv)
+	}
+	defer keyFile.Close()
+
+	listener, err := net.FileListener(listenerFile)
+	listenerFile.Close()
+	if err != nil {
+		return fmt.Errorf("failed to use agent socket: %w", err)
+	}
+
+	handoff, err := io.ReadAll(keyFile)
+	if err != nil || len(handoff) < 1 || len(handoff) != 1+int(handoff[0])+keySize {
+		listener.Close()
+		return fmt.Errorf("failed to receive vault key")
+	}
+	saltLen := int(handoff[0])
+	salt := append([]byte(nil), handoff[1:1+saltLen]...)
+	key := append([]byte(nil), handoff[1+saltLen:]...)
+	clearBytes(handoff)
+
+	// The vault may have been re-encrypted since the key was derived
+	vault, err := openVaultWithKey(getVaultPath(), key, salt)
+	if err != nil {
+		clearBytes(key)
+		listener.Close()
+		return err
+	}
+
+	socketPath := listener.Addr().String()
+	return runAgent(socketPath, listener, vault, opts.timeout)
+}
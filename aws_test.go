@@ -0,0 +1,37 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestConsoleDestination(t *testing.T) {
+	tests := []struct {
+		service, path, region string
+		want                  string
+		wantErr               bool
+	}{
+		{want: "https://console.aws.amazon.com/console/home"},
+		{region: "eu-west-1", want: "https://console.aws.amazon.com/console/home?region=eu-west-1"},
+		{service: "ec2", region: "eu-west-1", want: "https://console.aws.amazon.com/ec2/home?region=eu-west-1"},
+		{path: "/cloudwatch/home#logs:", region: "us-east-1", want: "https://console.aws.amazon.com/cloudwatch/home?region=us-east-1#logs:"},
+		// A region selected by the path wins
+		{path: "/s3/buckets?region=ap-south-1", region: "us-east-1", want: "https://console.aws.amazon.com/s3/buckets?region=ap-south-1"},
+		{service: "ec2", path: "/ec2/home", wantErr: true},
+		{service: "EC2 Dashboard", wantErr: true},
+		{path: "cloudwatch/home", wantErr: true},
+		{path: "//evil.example.com/", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := consoleDestination(tt.service, tt.path, tt.region)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("consoleDestination(%q, %q, %q) = %q, want error", tt.service, tt.path, tt.region, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("consoleDestination(%q, %q, %q) = %q, %v; want %q", tt.service, tt.path, tt.region, got, err, tt.want)
+		}
+	}
+}
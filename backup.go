@@ -0,0 +1,307 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"time"
+)
+
+const backupFormat = "caws-backup"
+
+// BackupFile is a portable archive of the vault contents, encrypted like a
+// vault but with its own passphrase
+type BackupFile struct {
+	Format    string    `json:"format"`
+	CreatedAt time.Time `json:"created_at"`
+	Vault     VaultFile `json:"vault"`
+}
+
+// readBackupPassphrase prompts for the passphrase sealing a backup, twice
+// when creating one. In test mode CAWS_BACKUP_PASSPHRASE is used if set.
+// Caller is responsible for clearing the bytes after use
+func readBackupPassphrase(confirm bool) ([]byte, error) {
+	if testPass := os.Getenv("CAWS_BACKUP_PASSPHRASE"); testPass != "" {
+		fmt.Fprintln(os.Stderr, "Enter backup passphrase: [test mode]")
+		return []byte(testPass), nil
+	}
+
+	if !confirm {
+		return readPasswordBytes("Enter backup passphrase: ")
+	}
+
+	passphrase, err := readNewPassword("Enter backup passphrase: ", "Confirm backup passphrase: ")
+	if err != nil {
+		return nil, err
+	}
+	if len(passphrase) == 0 {
+		return nil, fmt.Errorf("backup passphrase cannot be empty")
+	}
+	return passphrase, nil
+}
+
+// CreateBackup writes an encrypted archive of all vault profiles to path
+func CreateBackup(path string) error {
+	// Never overwrite an existing file, it may be an older backup
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("%s already exists", path)
+	}
+
+	client, err := NewVaultClient()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	data, err := client.read()
+	if err != nil {
+		return err
+	}
+
+	passphrase, err := readBackupPassphrase(true)
+	if err != nil {
+		return err
+	}
+	defer clearBytes(passphrase)
+
+	sealed, err := encryptVault(string(passphrase), data)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt backup: %w", err)
+	}
+
+	fileData, err := json.MarshalIndent(BackupFile{
+		Format:    backupFormat,
+		CreatedAt: time.Now().UTC(),
+		Vault:     *sealed,
+	}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal backup: %w", err)
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create backup file: %w", err)
+	}
+	if _, err := file.Write(fileData); err != nil {
+		file.Close()
+		os.Remove(path)
+		return fmt.Errorf("failed to write backup file: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(path)
+		return fmt.Errorf("failed to write backup file: %w", err)
+	}
+
+	fmt.Printf("✓ Backed up %d profile(s) to %s\n", len(data.Profiles), path)
+	return nil
+}
+
+// readBackup reads and decrypts a backup archive
+func readBackup(path string) (*BackupFile, *VaultData, error) {
+	fileData, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read backup file: %w", err)
+	}
+
+	var backup BackupFile
+	if err := json.Unmarshal(fileData, &backup); err != nil || backup.Format != backupFormat {
+		return nil, nil, fmt.Errorf("%s is not a caws backup", path)
+	}
+
+	passphrase, err := readBackupPassphrase(false)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer clearBytes(passphrase)
+
+	data, err := decryptVault(string(passphrase), &backup.Vault)
+	if err != nil {
+		return nil, nil, fmt.Errorf("incorrect backup passphrase or corrupted backup")
+	}
+
+	if data.Profiles == nil {
+		data.Profiles = make(map[string]ProfileData)
+	}
+	for name := range data.Profiles {
+		if err := validateProfileName(name); err != nil {
+			return nil, nil, fmt.Errorf("backup contains an invalid profile name: %w", err)
+		}
+	}
+
+	return &backup, data, nil
+}
+
+// restoreChange describes what a restore does to one profile
+type restoreChange struct {
+	profile string
+	action  string // "added", "updated", "unchanged" or "removed"
+}
+
+// planRestore computes the per-profile changes of restoring backup over
+// current. Profiles missing from the backup are only removed when replacing
+func planRestore(current, backup map[string]ProfileData, replace bool) []restoreChange {
+	var changes []restoreChange
+
+	for name, profile := range backup {
+		existing, exists := current[name]
+		switch {
+		case !exists:
+			changes = append(changes, restoreChange{name, "added"})
+		case existing != profile:
+			changes = append(changes, restoreChange{name, "updated"})
+		default:
+			changes = append(changes, restoreChange{name, "unchanged"})
+		}
+	}
+
+	if replace {
+		for name := range current {
+			if _, exists := backup[name]; !exists {
+				changes = append(changes, restoreChange{name, "removed"})
+			}
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].profile < changes[j].profile
+	})
+
+	return changes
+}
+
+// printRestoreSummary prints one line per profile
+func printRestoreSummary(changes []restoreChange) {
+	symbols := map[string]string{
+		"added":     "+",
+		"updated":   "~",
+		"unchanged": "=",
+		"removed":   "-",
+	}
+
+	for _, change := range changes {
+		fmt.Printf("  %s %-30s %s\n", symbols[change.action], change.profile, change.action)
+	}
+}
+
+// RestoreBackup restores profiles from a backup archive, merging them into
+// the vault or replacing its contents. Without a vault, a new one is created
+func RestoreBackup(path string, replace bool) error {
+	backup, backupData, err := readBackup(path)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Backup from %s contains %d profile(s)\n", backup.CreatedAt.Local().Format("2006-01-02 15:04"), len(backupData.Profiles))
+
+	vaultPath := getVaultPath()
+	if _, err := os.Stat(vaultPath); os.IsNotExist(err) {
+		return restoreToNewVault(vaultPath, backupData)
+	}
+
+	client, err := NewVaultClient()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	// Plan under a shared lock; no lock is held while the user decides
+	data, err := client.read()
+	if err != nil {
+		return err
+	}
+
+	changes := planRestore(data.Profiles, backupData.Profiles, replace)
+	printRestoreSummary(changes)
+
+	modified := false
+	for _, change := range changes {
+		if change.action != "unchanged" {
+			modified = true
+			break
+		}
+	}
+	if !modified {
+		fmt.Println("Vault already matches the backup, nothing to restore")
+		return nil
+	}
+
+	if !readConfirmation("Apply these changes to the vault? (yes/no): ") {
+		fmt.Println("Cancelled")
+		return nil
+	}
+
+	err = client.update(func(data *VaultData) error {
+		// Another caws may have changed the vault while the prompt was open
+		if !slices.Equal(planRestore(data.Profiles, backupData.Profiles, replace), changes) {
+			return fmt.Errorf("vault changed while waiting for confirmation, run restore again")
+		}
+		if data.Profiles == nil {
+			data.Profiles = make(map[string]ProfileData)
+		}
+
+		for _, change := range changes {
+			switch change.action {
+			case "added", "updated":
+				data.Profiles[change.profile] = backupData.Profiles[change.profile]
+			case "removed":
+				delete(data.Profiles, change.profile)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Cached credentials belong to the replaced keys
+	for _, change := range changes {
+		if change.action == "updated" || change.action == "removed" {
+			removeCachedCredentials(change.profile)
+		}
+	}
+
+	fmt.Printf("✓ Restored %s\n", path)
+	return nil
+}
+
+// restoreToNewVault creates a vault holding the backup's profiles
+func restoreToNewVault(vaultPath string, backupData *VaultData) error {
+	fmt.Printf("No vault found at %s, creating a new one\n", vaultPath)
+
+	if err := os.MkdirAll(filepath.Dir(vaultPath), 0700); err != nil {
+		return fmt.Errorf("failed to create vault directory: %w", err)
+	}
+
+	lockFile, err := acquireVaultLock(vaultPath, lockExclusive)
+	if err != nil {
+		return err
+	}
+	defer releaseVaultLock(lockFile)
+
+	// Another process may have created it before the lock was taken
+	if _, err := os.Stat(vaultPath); err == nil {
+		return fmt.Errorf("vault was created at %s during restore, run restore again", vaultPath)
+	}
+
+	passwordBytes, err := readNewPassword("Enter master password: ", "Confirm password: ")
+	if err != nil {
+		return err
+	}
+	defer clearBytes(passwordBytes)
+
+	vaultFile, err := encryptVault(string(passwordBytes), backupData)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt vault: %w", err)
+	}
+
+	if err := writeVaultFile(vaultPath, vaultFile); err != nil {
+		return err
+	}
+
+	printRestoreSummary(planRestore(nil, backupData.Profiles, false))
+	fmt.Printf("✓ Vault restored at %s\n", vaultPath)
+	return nil
+}
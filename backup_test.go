@@ -0,0 +1,54 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPlanRestore(t *testing.T) {
+	current := map[string]ProfileData{
+		"same":    {AccessKey: "AKIA1", SecretKey: "secret1"},
+		"changed": {AccessKey: "AKIA2", SecretKey: "secret2"},
+		"local":   {AccessKey: "AKIA3", SecretKey: "secret3"},
+	}
+	backup := map[string]ProfileData{
+		"same":    {AccessKey: "AKIA1", SecretKey: "secret1"},
+		"changed": {AccessKey: "AKIA2", SecretKey: "rotated"},
+		"new":     {AccessKey: "AKIA4", SecretKey: "secret4"},
+	}
+
+	tests := []struct {
+		name    string
+		replace bool
+		want    []restoreChange
+	}{
+		{
+			name:    "merge keeps profiles missing from backup",
+			replace: false,
+			want: []restoreChange{
+				{"changed", "updated"},
+				{"new", "added"},
+				{"same", "unchanged"},
+			},
+		},
+		{
+			name:    "replace removes profiles missing from backup",
+			replace: true,
+			want: []restoreChange{
+				{"changed", "updated"},
+				{"local", "removed"},
+				{"new", "added"},
+				{"same", "unchanged"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := planRestore(current, backup, tt.replace)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("planRestore() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
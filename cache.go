@@ -0,0 +1,433 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// cacheExpiryBuffer is how long before expiration cached credentials stop being used
+const cacheExpiryBuffer = 5 * time.Minute
+
+// cacheVersion is the format of encrypted cache files
+const cacheVersion = 1
+
+// errNoCacheKey means the cache key is gone (e.g. after logging out), so
+// every cache file written under it is unreadable and counts as a miss
+var errNoCacheKey = errors.New("cache key not found")
+
+// errKeyringUnavailable means the kernel keyring cannot be used and the cache
+// key is kept in a file instead
+var errKeyringUnavailable = errors.New("kernel keyring unavailable")
+
+// cacheFile is the on-disk form of cached credentials: STSCredentials JSON
+// sealed with AES-256-GCM under the cache key
+type cacheFile struct {
+	Version int    `json:"version"`
+	Nonce   string `json:"nonce"` // base64-encoded
+	Data    string `json:"data"`  // base64-encoded encrypted JSON
+}
+
+// cacheEntry identifies one set of cached credentials of a profile, so that
+// exec, login, role and down-scoped sessions are cached side by side
+// instead of evicting each other
+type cacheEntry struct {
+	Type   string // "session", "role", "sso" or "federation"
+	Role   string // role ARN, or account/role for IAM Identity Center
+	Policy string // session policy hash, empty for full sessions
+}
+
+// entryOf returns the cache entry that credentials are stored under
+func entryOf(creds *STSCredentials) cacheEntry {
+	role := creds.RoleARN
+	if creds.Type == "sso" {
+		role = creds.SSORole
+	}
+	return cacheEntry{Type: creds.Type, Role: role, Policy: creds.PolicyHash}
+}
+
+// name is the entry's file name (without .json) in the profile's cache
+// directory, e.g. "session" or "role-3f9c0a1b2c4d"
+func (e cacheEntry) name() string {
+	if e.Role == "" && e.Policy == "" {
+		return e.Type
+	}
+	sum := sha256.Sum256([]byte(e.Role + "\n" + e.Policy))
+	return e.Type + "-" + hex.EncodeToString(sum[:6])
+}
+
+// cachedCredentials is an entry found in the cache directory
+type cachedCredentials struct {
+	profile string
+	name    string
+	path    string
+	creds   *STSCredentials // nil if the entry cannot be decrypted
+}
+
+// expired reports whether the entry can no longer be used
+func (c cachedCredentials) expired() bool {
+	return c.creds == nil || time.Now().Add(cacheExpiryBuffer).After(c.creds.Expiration)
+}
+
+// GetCachedCredentials retrieves cached credentials of a profile if still valid
+func GetCachedCredentials(profile string, entry cacheEntry) (*STSCredentials, error) {
+	removeLegacyCache(profile)
+
+	key, err := getCacheKey(false)
+	if err != nil {
+		return nil, err
+	}
+	defer clearBytes(key)
+
+	creds, err := readCacheFile(getCacheEntryPath(profile, entry.name()), profile, entry.name(), key)
+	if err != nil {
+		return nil, err
+	}
+
+	// Check if expired (with 5 minute buffer)
+	if time.Now().Add(cacheExpiryBuffer).After(creds.Expiration) {
+		return nil, fmt.Errorf("cached credentials expired")
+	}
+
+	return creds, nil
+}
+
+// CacheCredentials saves credentials to cache, replacing the profile's entry
+// of the same type, role and policy
+func CacheCredentials(profile string, creds *STSCredentials) error {
+	removeLegacyCache(profile)
+
+	name := entryOf(creds).name()
+	cachePath := getCacheEntryPath(profile, name)
+	if err := os.MkdirAll(filepath.Dir(cachePath), 0700); err != nil {
+		return fmt.Errorf("failed to create cache directory: %w", err)
+	}
+
+	key, err := getCacheKey(true)
+	if err != nil {
+		return err
+	}
+	defer clearBytes(key)
+
+	file, err := encryptCache(key, profile+"/"+name, creds)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal cache file: %w", err)
+	}
+
+	if err := os.WriteFile(cachePath, data, 0600); err != nil {
+		return fmt.Errorf("failed to write cache file: %w", err)
+	}
+
+	return nil
+}
+
+// readCacheFile reads and decrypts a cache entry without checking its expiration
+func readCacheFile(cachePath, profile, name string, key []byte) (*STSCredentials, error) {
+	data, err := os.ReadFile(cachePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var file cacheFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+
+	return decryptCache(key, profile+"/"+name, &file)
+}
+
+// listCachedCredentials returns the cache entries of a profile, or of all
+// profiles if profile is empty, sorted by profile and entry. Entries that
+// cannot be decrypted are included with nil credentials. Cache files of
+// older caws versions are removed on the way
+func listCachedCredentials(profile string) ([]cachedCredentials, error) {
+	key, err := getCacheKey(false)
+	if err != nil && !errors.Is(err, errNoCacheKey) {
+		return nil, err
+	}
+	defer clearBytes(key)
+
+	dirs, err := os.ReadDir(getCacheDir())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cache directory: %w", err)
+	}
+
+	var entries []cachedCredentials
+	for _, dir := range dirs {
+		if !dir.IsDir() {
+			legacy := strings.TrimSuffix(dir.Name(), ".json")
+			if legacy != dir.Name() && (profile == "" || legacy == profile) {
+				removeLegacyCache(legacy)
+			}
+			continue
+		}
+		if profile != "" && dir.Name() != profile {
+			continue
+		}
+
+		files, err := os.ReadDir(filepath.Join(getCacheDir(), dir.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read cache directory: %w", err)
+		}
+		for _, file := range files {
+			name := strings.TrimSuffix(file.Name(), ".json")
+			if file.IsDir() || name == file.Name() {
+				continue
+			}
+
+			entry := cachedCredentials{
+				profile: dir.Name(),
+				name:    name,
+				path:    getCacheEntryPath(dir.Name(), name),
+			}
+			if key != nil {
+				// Unreadable entries were written under a lost key
+				entry.creds, _ = readCacheFile(entry.path, entry.profile, name, key)
+			}
+			entries = append(entries, entry)
+		}
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].profile != entries[j].profile {
+			return entries[i].profile < entries[j].profile
+		}
+		return entries[i].name < entries[j].name
+	})
+
+	return entries, nil
+}
+
+// removeCachedCredentials removes every cache entry of a profile
+func removeCachedCredentials(profile string) error {
+	if err := os.RemoveAll(filepath.Join(getCacheDir(), profile)); err != nil {
+		return fmt.Errorf("failed to clear cached credentials: %w", err)
+	}
+	if err := os.Remove(getLegacyCachePath(profile)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to clear cached credentials: %w", err)
+	}
+	return nil
+}
+
+// removeLegacyCache removes the single <profile>.json that caws versions
+// before cache entries wrote, unencrypted before that. It is never read, but
+// may still hold a live session token
+func removeLegacyCache(profile string) {
+	if err := os.Remove(getLegacyCachePath(profile)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		fmt.Fprintf(os.Stderr, "Warning: failed to remove old cache file: %v\n", err)
+	}
+}
+
+// encryptCache seals credentials under the cache key. The entry's
+// "profile/name" is authenticated too, so a cache file cannot be passed off
+// as another profile's or entry's
+func encryptCache(key []byte, entry string, creds *STSCredentials) (*cacheFile, error) {
+	plaintext, err := json.Marshal(creds)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal credentials: %w", err)
+	}
+	defer clearBytes(plaintext)
+
+	gcm, err := newCacheGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	ciphertext := gcm.Seal(nil, nonce, plaintext, []byte(entry))
+
+	return &cacheFile{
+		Version: cacheVersion,
+		Nonce:   base64.StdEncoding.EncodeToString(nonce),
+		Data:    base64.StdEncoding.EncodeToString(ciphertext),
+	}, nil
+}
+
+// decryptCache opens a cache file sealed by encryptCache
+func decryptCache(key []byte, entry string, file *cacheFile) (*STSCredentials, error) {
+	if file.Version != cacheVersion {
+		return nil, fmt.Errorf("unsupported cache version: %d", file.Version)
+	}
+
+	nonce, err := base64.StdEncoding.DecodeString(file.Nonce)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode nonce: %w", err)
+	}
+
+	ciphertext, err := base64.StdEncoding.DecodeString(file.Data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode data: %w", err)
+	}
+
+	gcm, err := newCacheGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(nonce) != gcm.NonceSize() {
+		return nil, fmt.Errorf("invalid nonce length: %d", len(nonce))
+	}
+
+	// Fails when the key was replaced since the file was written
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, []byte(entry))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt cache: %w", err)
+	}
+	defer clearBytes(plaintext)
+
+	var creds STSCredentials
+	if err := json.Unmarshal(plaintext, &creds); err != nil {
+		return nil, fmt.Errorf("failed to parse cached credentials: %w", err)
+	}
+
+	return &creds, nil
+}
+
+func newCacheGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCM: %w", err)
+	}
+
+	return gcm, nil
+}
+
+// getCacheKey returns the key that encrypts the credential cache. It lives
+// in the kernel keyring where available, so it never touches the disk and
+// goes away when the user logs out. Elsewhere, and in test mode, it is kept
+// in a file under the runtime directory. With create, a missing key is
+// generated; otherwise errNoCacheKey is returned
+func getCacheKey(create bool) ([]byte, error) {
+	if os.Getenv("CAWS_TEST_DIR") == "" {
+		key, err := keyringCacheKey(create)
+		if !errors.Is(err, errKeyringUnavailable) {
+			return key, err
+		}
+	}
+
+	return fileCacheKey(create)
+}
+
+// newCacheKey generates a random cache key
+func newCacheKey() ([]byte, error) {
+	key := make([]byte, keySize)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, fmt.Errorf("failed to generate cache key: %w", err)
+	}
+	return key, nil
+}
+
+// fileCacheKey reads the cache key from getCacheKeyPath, creating it if asked to
+func fileCacheKey(create bool) ([]byte, error) {
+	keyPath := getCacheKeyPath()
+
+	// A key planted by another user would let them read the cache
+	if err := ensureRuntimeDir(filepath.Dir(keyPath)); err != nil {
+		return nil, fmt.Errorf("unsafe cache key directory: %w", err)
+	}
+
+	key, err := readCacheKeyFile(keyPath)
+	if !errors.Is(err, errNoCacheKey) || !create {
+		return key, err
+	}
+
+	key, err = newCacheKey()
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(keyPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if errors.Is(err, os.ErrExist) {
+		// Another caws process created it first
+		clearBytes(key)
+		return readCacheKeyFile(keyPath)
+	}
+	if err != nil {
+		clearBytes(key)
+		return nil, fmt.Errorf("failed to create cache key: %w", err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write(key); err != nil {
+		clearBytes(key)
+		os.Remove(keyPath)
+		return nil, fmt.Errorf("failed to write cache key: %w", err)
+	}
+
+	return key, nil
+}
+
+func readCacheKeyFile(keyPath string) ([]byte, error) {
+	key, err := os.ReadFile(keyPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, errNoCacheKey
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cache key: %w", err)
+	}
+	if len(key) != keySize {
+		clearBytes(key)
+		return nil, fmt.Errorf("invalid cache key in %s", keyPath)
+	}
+	return key, nil
+}
+
+// getCacheKeyPath returns where the cache key is kept without a kernel keyring
+func getCacheKeyPath() string {
+	if testDir := os.Getenv("CAWS_TEST_DIR"); testDir != "" {
+		return filepath.Join(testDir, "cache.key")
+	}
+
+	// The runtime directory is usually a tmpfs cleared on logout
+	return filepath.Join(getXDGRuntimeDir(), "caws", "cache.key")
+}
+
+// getLegacyCachePath returns the cache file of a profile in older caws versions
+func getLegacyCachePath(profile string) string {
+	return filepath.Join(getCacheDir(), profile+".json")
+}
+
+// getCacheEntryPath returns the file of a profile's cache entry
+func getCacheEntryPath(profile, name string) string {
+	return filepath.Join(getCacheDir(), profile, name+".json")
+}
+
+// getCacheDir returns the cache directory path
+func getCacheDir() string {
+	// Check for test mode
+	if testDir := os.Getenv("CAWS_TEST_DIR"); testDir != "" {
+		return filepath.Join(testDir, "cache")
+	}
+
+	// XDG-compliant path: $XDG_CACHE_HOME/caws
+	cacheHome := getXDGCacheHome()
+	return filepath.Join(cacheHome, "caws")
+}
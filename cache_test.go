@@ -0,0 +1,140 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestCacheEncryptionRoundTrip(t *testing.T) {
+	t.Setenv("CAWS_TEST_DIR", t.TempDir())
+
+	// Reading never creates a key
+	if _, err := getCacheKey(false); !errors.Is(err, errNoCacheKey) {
+		t.Fatalf("expected errNoCacheKey, got %v", err)
+	}
+
+	key, err := getCacheKey(true)
+	if err != nil {
+		t.Fatalf("getCacheKey failed: %v", err)
+	}
+	again, err := getCacheKey(false)
+	if err != nil || string(again) != string(key) {
+		t.Fatalf("cache key should be stable: %v", err)
+	}
+
+	creds := &STSCredentials{
+		AccessKeyID:     "ASIAEXAMPLE",
+		SecretAccessKey: "secret",
+		SessionToken:    "token",
+		Expiration:      time.Now().Add(time.Hour).Round(time.Second),
+		Type:            "session",
+	}
+
+	file, err := encryptCache(key, "work/session", creds)
+	if err != nil {
+		t.Fatalf("encryptCache failed: %v", err)
+	}
+
+	got, err := decryptCache(key, "work/session", file)
+	if err != nil {
+		t.Fatalf("decryptCache failed: %v", err)
+	}
+	if got.SecretAccessKey != creds.SecretAccessKey || !got.Expiration.Equal(creds.Expiration) {
+		t.Errorf("round trip mismatch: %+v", got)
+	}
+
+	if _, err := decryptCache(key, "other/session", file); err == nil {
+		t.Error("a cache file should not decrypt for another profile")
+	}
+
+	otherKey, _ := newCacheKey()
+	if _, err := decryptCache(otherKey, "work/session", file); err == nil {
+		t.Error("a cache file should not decrypt under another key")
+	}
+
+	// Cached credentials become a miss once the key is gone
+	if err := CacheCredentials("work", creds); err != nil {
+		t.Fatalf("CacheCredentials failed: %v", err)
+	}
+	if _, err := GetCachedCredentials("work", cacheEntry{Type: "session"}); err != nil {
+		t.Fatalf("GetCachedCredentials failed: %v", err)
+	}
+	os.Remove(getCacheKeyPath())
+	if _, err := GetCachedCredentials("work", cacheEntry{Type: "session"}); !errors.Is(err, errNoCacheKey) {
+		t.Errorf("expected errNoCacheKey, got %v", err)
+	}
+}
+
+func TestCacheEntries(t *testing.T) {
+	t.Setenv("CAWS_TEST_DIR", t.TempDir())
+
+	expiration := time.Now().Add(time.Hour)
+	role := "arn:aws:iam::123456789012:role/Admin"
+	for _, creds := range []*STSCredentials{
+		{AccessKeyID: "ASIASESSION", Type: "session", Expiration: expiration},
+		{AccessKeyID: "ASIAFEDERATION", Type: "federation", Expiration: expiration},
+		{AccessKeyID: "ASIAROLE", Type: "role", RoleARN: role, Expiration: expiration},
+		{AccessKeyID: "ASIAREADONLY", Type: "role", RoleARN: role, PolicyHash: "0123456789abcdef", Expiration: expiration},
+		{AccessKeyID: "ASIAEXPIRED", Type: "sso", SSORole: "123456789012/Dev", Expiration: time.Now().Add(time.Minute)},
+	} {
+		if err := CacheCredentials("work", creds); err != nil {
+			t.Fatalf("CacheCredentials failed: %v", err)
+		}
+	}
+
+	// Every type, role and policy has its own entry
+	for entry, want := range map[cacheEntry]string{
+		{Type: "session"}:          "ASIASESSION",
+		{Type: "federation"}:       "ASIAFEDERATION",
+		{Type: "role", Role: role}: "ASIAROLE",
+		{Type: "role", Role: role, Policy: "0123456789abcdef"}: "ASIAREADONLY",
+	} {
+		creds, err := GetCachedCredentials("work", entry)
+		if err != nil || creds.AccessKeyID != want {
+			t.Errorf("GetCachedCredentials(%+v) = %+v, %v; want %s", entry, creds, err, want)
+		}
+	}
+	if _, err := GetCachedCredentials("work", cacheEntry{Type: "role", Role: "arn:aws:iam::123456789012:role/Other"}); err == nil {
+		t.Error("another role should be a miss")
+	}
+	if _, err := GetCachedCredentials("work", cacheEntry{Type: "sso", Role: "123456789012/Dev"}); err == nil {
+		t.Error("credentials within the expiry buffer should be a miss")
+	}
+
+	// Cache files of older versions are removed once their profile's cache is used
+	os.WriteFile(getLegacyCachePath("work"), []byte(`{"Type":"session"}`), 0600)
+	os.WriteFile(getLegacyCachePath("old"), []byte(`{"Type":"session"}`), 0600)
+	GetCachedCredentials("work", cacheEntry{Type: "session"})
+	if _, err := os.Stat(getLegacyCachePath("work")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("old cache file of work not removed: %v", err)
+	}
+
+	entries, err := listCachedCredentials("")
+	if err != nil {
+		t.Fatalf("listCachedCredentials failed: %v", err)
+	}
+	if _, err := os.Stat(getLegacyCachePath("old")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("old cache file not removed by listing: %v", err)
+	}
+	if len(entries) != 5 || entries[0].profile != "work" {
+		t.Fatalf("unexpected entries: %+v", entries)
+	}
+	expired := 0
+	for _, entry := range entries {
+		if entry.expired() {
+			expired++
+		}
+	}
+	if expired != 1 {
+		t.Errorf("expected the sso entry to be expired, got %d", expired)
+	}
+
+	if err := removeCachedCredentials("work"); err != nil {
+		t.Fatalf("removeCachedCredentials failed: %v", err)
+	}
+	if entries, _ := listCachedCredentials("work"); len(entries) != 0 {
+		t.Errorf("entries left after removal: %+v", entries)
+	}
+}
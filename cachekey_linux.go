@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// cacheKeyDescription names the cache key in the user keyring
+// (see `keyctl show @u`)
+const cacheKeyDescription = "caws:cache-key"
+
+// cacheKeyPerm lets the key be found and read by the same user from any
+// login session, not only the one that created it: possessor all, user
+// view, read and search
+const cacheKeyPerm = 0x3f000000 | 0x00010000 | 0x00020000 | 0x00080000
+
+// keyringCacheKey reads the cache key from the user keyring, which the
+// kernel discards once the user's last session ends
+func keyringCacheKey(create bool) ([]byte, error) {
+	id, err := unix.KeyctlSearch(unix.KEY_SPEC_USER_KEYRING, "user", cacheKeyDescription, 0)
+	if err == nil {
+		return readKeyringKey(id)
+	}
+	if keyringUnavailable(err) {
+		return nil, errKeyringUnavailable
+	}
+	if !errors.Is(err, unix.ENOKEY) && !errors.Is(err, unix.EKEYEXPIRED) && !errors.Is(err, unix.EKEYREVOKED) {
+		return nil, fmt.Errorf("failed to search keyring for cache key: %w", err)
+	}
+	if !create {
+		return nil, errNoCacheKey
+	}
+
+	key, err := newCacheKey()
+	if err != nil {
+		return nil, err
+	}
+
+	id, err = unix.AddKey("user", cacheKeyDescription, key, unix.KEY_SPEC_USER_KEYRING)
+	if err != nil {
+		clearBytes(key)
+		if keyringUnavailable(err) {
+			return nil, errKeyringUnavailable
+		}
+		return nil, fmt.Errorf("failed to add cache key to keyring: %w", err)
+	}
+	if err := unix.KeyctlSetperm(id, cacheKeyPerm); err != nil {
+		clearBytes(key)
+		return nil, fmt.Errorf("failed to set cache key permissions: %w", err)
+	}
+
+	return key, nil
+}
+
+func readKeyringKey(id int) ([]byte, error) {
+	buf := make([]byte, keySize)
+	n, err := unix.KeyctlBuffer(unix.KEYCTL_READ, id, buf, 0)
+	if err != nil {
+		clearBytes(buf)
+		if errors.Is(err, unix.EKEYEXPIRED) || errors.Is(err, unix.EKEYREVOKED) || errors.Is(err, unix.ENOKEY) {
+			return nil, errNoCacheKey
+		}
+		return nil, fmt.Errorf("failed to read cache key from keyring: %w", err)
+	}
+	if n != keySize {
+		clearBytes(buf)
+		return nil, fmt.Errorf("invalid cache key in keyring (%d bytes)", n)
+	}
+	return buf, nil
+}
+
+// keyringUnavailable reports errors of kernels without keyring support and
+// of sandboxes that block the keyctl syscalls, such as default Docker
+// seccomp profiles
+func keyringUnavailable(err error) bool {
+	return errors.Is(err, unix.ENOSYS) || errors.Is(err, unix.EPERM) || errors.Is(err, unix.EACCES)
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package main
+
+// keyringCacheKey is only implemented on Linux; other systems keep the
+// cache key in a file
+func keyringCacheKey(create bool) ([]byte, error) {
+	return nil, errKeyringUnavailable
+}
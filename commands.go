@@ -2,11 +2,20 @@ package main
 
 import (
 	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
+	"net/http"
 	"os"
 	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"runtime"
 	"strings"
 	"syscall"
+	"time"
 
 	"golang.org/x/term"
 )
@@ -57,7 +66,7 @@ func handleAdd(profile string) error {
 	}
 
 	// Now proceed with adding credentials to vault
-	client, err := NewVaultClient()
+	client, err := openCredentialStore()
 	if err != nil {
 		return err
 	}
@@ -120,26 +129,187 @@ func handleAdd(profile string) error {
 		if configSettings.Region != "" {
 			fmt.Printf("Using region '%s' from ~/.aws/config\n", configSettings.Region)
 		} else {
-			fmt.Println("Tip: Add region to ~/.aws/config:")
-			fmt.Printf("  [profile %s]\n", profile)
-			fmt.Println("  region = us-east-1")
+			fmt.Println("Tip: Set a region with:")
+			fmt.Printf("  caws config set %s region us-east-1\n", profile)
 		}
 
 		if configSettings.MFASerial != "" {
 			fmt.Println("MFA configured in ~/.aws/config")
 		} else {
-			fmt.Println("\nTip: To enable MFA, add to ~/.aws/config:")
-			fmt.Printf("  [profile %s]\n", profile)
-			fmt.Println("  mfa_serial = arn:aws:iam::123456789012:mfa/your-username")
+			fmt.Println("\nTip: To enable MFA, run:")
+			fmt.Printf("  caws config set %s mfa_serial arn:aws:iam::123456789012:mfa/your-username\n", profile)
 		}
 	}
 
 	return nil
 }
 
+// importOptions holds the flags of the import command
+type importOptions struct {
+	csvPath         string // IAM console accessKeys.csv; the credentials file otherwise
+	profile         string // profile to import (required with csvPath)
+	removePlaintext bool
+}
+
+// handleImport handles importing long-term keys from plaintext files
+func handleImport(opts importOptions) error {
+	var entries []importEntry
+	var sourcePath string
+
+	if opts.csvPath != "" {
+		if opts.profile == "" {
+			return fmt.Errorf("--profile is required when importing a CSV file")
+		}
+		file, err := os.Open(opts.csvPath)
+		if err != nil {
+			return fmt.Errorf("failed to open CSV file: %w", err)
+		}
+		accessKey, secretKey, err := parseAccessKeysCSV(file)
+		file.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", opts.csvPath, err)
+		}
+		entries = []importEntry{{profile: opts.profile, accessKey: accessKey, secretKey: secretKey}}
+		sourcePath = opts.csvPath
+	} else {
+		credentialsPath, err := getSharedCredentialsPath()
+		if err != nil {
+			return err
+		}
+		file, err := os.Open(credentialsPath)
+		if err != nil {
+			return fmt.Errorf("failed to open credentials file: %w", err)
+		}
+		entries, err = parseCredentialsFile(file)
+		file.Close()
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", credentialsPath, err)
+		}
+		sourcePath = credentialsPath
+
+		if opts.profile != "" {
+			var selected []importEntry
+			for _, entry := range entries {
+				if entry.profile == opts.profile {
+					selected = append(selected, entry)
+				}
+			}
+			if len(selected) == 0 {
+				return fmt.Errorf("profile '%s' not found in %s", opts.profile, credentialsPath)
+			}
+			entries = selected
+		}
+	}
+
+	if len(entries) == 0 {
+		fmt.Printf("No profiles found in %s\n", sourcePath)
+		return nil
+	}
+
+	client, err := openCredentialStore()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	existing, err := client.ListProfiles()
+	if err != nil {
+		return fmt.Errorf("failed to list profiles: %w", err)
+	}
+	inVault := make(map[string]bool)
+	for _, profile := range existing {
+		inVault[profile.Name] = true
+	}
+
+	fmt.Printf("Importing from %s\n", sourcePath)
+
+	// Profiles whose keys are safely in the vault, so their plaintext can go
+	stored := make(map[string]bool)
+	imported, skipped := 0, 0
+
+	for _, entry := range entries {
+		reason := ""
+		switch {
+		case validateProfileName(entry.profile) != nil:
+			reason = validateProfileName(entry.profile).Error()
+		case entry.accessKey == "" && entry.secretKey == "":
+			reason = "no access keys"
+		case entry.sessionToken || strings.HasPrefix(entry.accessKey, "ASIA"):
+			reason = "temporary credentials"
+		case validateAccessKey(entry.accessKey) != nil:
+			reason = validateAccessKey(entry.accessKey).Error()
+		case entry.secretKey == "":
+			reason = "missing secret access key"
+		}
+		if reason != "" {
+			fmt.Printf("  - %s: skipped (%s)\n", entry.profile, reason)
+			skipped++
+			continue
+		}
+
+		if inVault[entry.profile] {
+			creds, err := client.GetCredentials(entry.profile)
+			if err != nil {
+				return fmt.Errorf("failed to read profile '%s': %w", entry.profile, err)
+			}
+			if creds.AccessKeyID == entry.accessKey && creds.SecretAccessKey == entry.secretKey {
+				fmt.Printf("  = %s: already in vault\n", entry.profile)
+				stored[entry.profile] = true
+			} else {
+				fmt.Printf("  - %s: skipped (vault has different keys; run 'caws remove %s' first to replace them)\n", entry.profile, entry.profile)
+				skipped++
+			}
+			continue
+		}
+
+		if err := client.CreateCredentials(entry.profile, entry.accessKey, entry.secretKey); err != nil {
+			return fmt.Errorf("failed to add profile '%s' to vault: %w", entry.profile, err)
+		}
+		stored[entry.profile] = true
+		imported++
+
+		exists, err := profileExistsInConfig(entry.profile)
+		if err != nil {
+			return fmt.Errorf("failed to check ~/.aws/config: %w", err)
+		}
+		if !exists {
+			if err := createConfigProfile(entry.profile); err != nil {
+				return fmt.Errorf("failed to create profile in config: %w", err)
+			}
+			fmt.Printf("  + %s: imported (created [profile %s] in ~/.aws/config)\n", entry.profile, entry.profile)
+		} else {
+			fmt.Printf("  + %s: imported\n", entry.profile)
+		}
+	}
+
+	fmt.Printf("\n✓ Imported %d profile(s), skipped %d\n", imported, skipped)
+
+	if !opts.removePlaintext || len(stored) == 0 {
+		if len(stored) > 0 {
+			fmt.Printf("Plaintext keys are still in %s; re-run with --remove-plaintext to delete them\n", sourcePath)
+		}
+		return nil
+	}
+
+	if opts.csvPath != "" {
+		if err := os.Remove(opts.csvPath); err != nil {
+			return fmt.Errorf("failed to remove %s: %w", opts.csvPath, err)
+		}
+		fmt.Printf("✓ Removed %s\n", opts.csvPath)
+		return nil
+	}
+
+	if err := removePlaintextCredentials(sourcePath, stored); err != nil {
+		return err
+	}
+	fmt.Printf("✓ Removed plaintext keys of %d profile(s) from %s\n", len(stored), sourcePath)
+
+	return nil
+}
+
 // handleList handles listing AWS profiles
 func handleList() error {
-	client, err := NewVaultClient()
+	client, err := openCredentialStore()
 	if err != nil {
 		return err
 	}
@@ -170,13 +340,48 @@ func handleList() error {
 	return nil
 }
 
+// execOptions holds the flags of the exec command
+type execOptions struct {
+	// Serve credentials from a loopback container credentials endpoint
+	// instead of passing static credentials to the child
+	server bool
+
+	// Requested credential lifetime, 0 for the profile's default
+	duration time.Duration
+
+	// Session policies that down-scope the credentials
+	policy policyFlags
+
+	// MFA code to use instead of prompting
+	mfaToken string
+
+	// Execute the command in place of caws instead of running it as a child
+	replace bool
+}
+
 // handleExec handles executing a command with AWS credentials
-func handleExec(profile string, args []string) error {
+func handleExec(profile string, args []string, opts execOptions) error {
 	// Validate profile name
 	if err := validateProfileName(profile); err != nil {
 		return err
 	}
 
+	duration, err := durationSeconds(opts.duration)
+	if err != nil {
+		return err
+	}
+	policy, err := opts.policy.load()
+	if err != nil {
+		return err
+	}
+	if opts.mfaToken != "" && !mfaCodePattern.MatchString(opts.mfaToken) {
+		return fmt.Errorf("invalid --mfa-token %q (expected 6 digits)", opts.mfaToken)
+	}
+	if opts.replace && opts.server {
+		return fmt.Errorf("--exec-replace cannot be used with --server, which keeps caws running to serve credentials")
+	}
+	session := sessionOptions{duration: duration, policy: policy, mfaToken: opts.mfaToken}
+
 	// Skip "--" if present
 	if len(args) > 0 && args[0] == "--" {
 		args = args[1:]
@@ -185,70 +390,40 @@ func handleExec(profile string, args []string) error {
 	// Determine if we're spawning a shell or running a command
 	spawnShell := len(args) == 0
 
-	// Check for cached credentials FIRST (before prompting for password)
-	stsCreds, err := GetCachedCredentials(profile)
-	if err != nil || stsCreds.Type != "session" {
-		// Cache miss, expired, or wrong type - need to get fresh credentials from vault
-		client, err := NewVaultClient()
-		if err != nil {
-			return err
-		}
-		defer client.Close()
+	var env []string
+	var validity string
 
-		// Get credentials from vault
-		creds, err := client.GetCredentials(profile)
-		if err != nil {
-			return fmt.Errorf("failed to get profile '%s': %w\nRun 'caws list' to see available profiles", profile, err)
-		}
+	vault := &vaultSession{}
+	defer vault.Close()
 
-		// Get region and MFA from ~/.aws/config
-		configSettings, err := getConfigSettings(profile)
+	if opts.server {
+		// Fetch credentials up front so prompts happen before the child starts
+		provider := newRefreshingProvider(profile, session)
+		stsCreds, err := provider.Retrieve()
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Warning: Failed to read ~/.aws/config: %v\n", err)
-			configSettings = &ConfigSettings{} // Use empty settings
-		}
-
-		// Set region (default to us-east-1 if not configured)
-		if configSettings.Region != "" {
-			creds.Region = configSettings.Region
-		} else {
-			creds.Region = "us-east-1"
-			fmt.Fprintln(os.Stderr, "⚠️  Warning: No region configured in ~/.aws/config, using us-east-1")
+			return err
 		}
 
-		// Set MFA serial if configured
-		creds.MFASerial = configSettings.MFASerial
-
-		fmt.Println("Getting temporary credentials...")
-
-		// Get MFA code if needed
-		var mfaCode string
-		if creds.MFASerial != "" {
-			fmt.Print("Enter MFA code: ")
-			reader := bufio.NewReader(os.Stdin)
-			mfaCode, _ = reader.ReadString('\n')
-			mfaCode = strings.TrimSpace(mfaCode)
+		server, err := startECSServer(provider)
+		if err != nil {
+			return err
 		}
+		// Shut the server down as soon as the child exits
+		defer server.Stop()
 
-		// Get temporary credentials
-		stsCreds, err = AssumeRole(creds, 3600, mfaCode)
+		env = SetContainerEnvVars(profile, server.URL, server.Token, stsCreds.Region)
+		validity = "Credentials are served from " + server.URL + " and refreshed automatically"
+	} else {
+		// Resolve credentials, opening the vault only on a cache miss
+		stsCreds, err := getSessionCredentials(profile, session, vault)
 		if err != nil {
-			return fmt.Errorf("failed to get temporary credentials: %w", err)
+			return err
 		}
 
-		// Cache them
-		if err := CacheCredentials(profile, stsCreds); err != nil {
-			fmt.Fprintf(os.Stderr, "Warning: failed to cache credentials: %v\n", err)
-		} else {
-			fmt.Printf("✓ Credentials cached (valid until %s)\n", stsCreds.Expiration.Format("15:04:05"))
-		}
-	} else {
-		fmt.Printf("Using cached credentials (valid until %s)\n", stsCreds.Expiration.Format("15:04:05"))
+		env = SetEnvVars(profile, stsCreds, stsCreds.Region)
+		validity = "Credentials valid until " + stsCreds.Expiration.Format("15:04:05")
 	}
 
-	// Set up environment
-	env := SetEnvVars(profile, stsCreds, stsCreds.Region)
-
 	var cmd *exec.Cmd
 
 	if spawnShell {
@@ -261,7 +436,7 @@ func handleExec(profile string, args []string) error {
 		cmd = exec.Command(shell)
 
 		fmt.Printf("Spawning subshell with AWS credentials for profile '%s'\n", profile)
-		fmt.Printf("Credentials valid until %s\n", stsCreds.Expiration.Format("15:04:05"))
+		fmt.Println(validity)
 		fmt.Println("Type 'exit' to return to your normal shell")
 		fmt.Println()
 	} else {
@@ -270,16 +445,198 @@ func handleExec(profile string, args []string) error {
 	}
 
 	cmd.Env = env
+
+	if opts.replace {
+		// Nothing deferred runs once the command replaces caws
+		vault.Close()
+		if err := replaceProcess(cmd); err != nil {
+			return fmt.Errorf("failed to execute command: %w", err)
+		}
+	}
+
 	cmd.Stdin = os.Stdin
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 
-	if err := cmd.Run(); err != nil {
-		if exitErr, ok := err.(*exec.ExitError); ok {
-			os.Exit(exitErr.ExitCode())
-		}
+	status, err := runCommand(cmd)
+	if err != nil {
 		return fmt.Errorf("failed to execute command: %w", err)
 	}
+	if status != 0 {
+		return exitStatus(status)
+	}
+
+	return nil
+}
+
+// handleExport handles printing credentials for use by other tools.
+// Only the credentials are written to stdout; prompts and status go elsewhere
+func handleExport(profile, format string) error {
+	// Validate profile name
+	if err := validateProfileName(profile); err != nil {
+		return err
+	}
+
+	if format != "credential-process" {
+		return fmt.Errorf("unsupported export format %q (supported: credential-process)", format)
+	}
+
+	// Resolve credentials, opening the vault only on a cache miss
+	vault := &vaultSession{}
+	defer vault.Close()
+
+	stsCreds, err := getSessionCredentials(profile, sessionOptions{}, vault)
+	if err != nil {
+		return err
+	}
+
+	output := CredentialProcessOutput{
+		Version:         1,
+		AccessKeyID:     stsCreds.AccessKeyID,
+		SecretAccessKey: stsCreds.SecretAccessKey,
+		SessionToken:    stsCreds.SessionToken,
+		Expiration:      stsCreds.Expiration.UTC().Format(time.RFC3339),
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(output)
+}
+
+// handleServe handles running a local EC2 instance metadata (IMDSv2) server
+func handleServe(profile, addr string) error {
+	// Validate profile name
+	if err := validateProfileName(profile); err != nil {
+		return err
+	}
+
+	addr, err := resolveLoopbackAddr(addr)
+	if err != nil {
+		return err
+	}
+
+	// Fetch credentials up front so prompts and errors happen before serving
+	provider := newRefreshingProvider(profile, sessionOptions{})
+	if _, err := provider.Retrieve(); err != nil {
+		return err
+	}
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+
+	server := &http.Server{
+		Handler:           newIMDSHandler(profile, provider.Retrieve),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go provider.RefreshLoop(done)
+
+	endpoint := fmt.Sprintf("http://%s/", listener.Addr())
+	fmt.Fprintf(os.Stderr, "Serving EC2 metadata credentials for profile '%s' on %s\n", profile, endpoint)
+	fmt.Fprintf(os.Stderr, "Use it with: export AWS_EC2_METADATA_SERVICE_ENDPOINT=%s\n", endpoint)
+	fmt.Fprintln(os.Stderr, "Press Ctrl+C to stop")
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- server.Serve(listener)
+	}()
+
+	select {
+	case err := <-serveErr:
+		return fmt.Errorf("metadata server failed: %w", err)
+	case <-signals:
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return server.Shutdown(ctx)
+}
+
+// handleMFASetSeed stores the TOTP secret of a profile's virtual MFA device,
+// so exec can generate MFA codes without a phone
+func handleMFASetSeed(profile string) error {
+	// Validate profile name
+	if err := validateProfileName(profile); err != nil {
+		return err
+	}
+
+	client, err := openCredentialStore()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	// Fail before asking for the seed if the profile is unknown
+	if _, err := client.GetCredentials(profile); err != nil {
+		return fmt.Errorf("failed to get profile '%s': %w\nRun 'caws list' to see available profiles", profile, err)
+	}
+
+	// Get the seed (hidden input)
+	var input string
+	if testSeed := os.Getenv("CAWS_TEST_MFA_SEED"); testSeed != "" {
+		fmt.Println("MFA seed (base32 secret or otpauth:// URI): [test mode]")
+		input = testSeed
+	} else {
+		inputBytes, err := readHiddenInput("MFA seed (base32 secret or otpauth:// URI): ")
+		if err != nil {
+			return fmt.Errorf("failed to read MFA seed: %w", err)
+		}
+		input = string(inputBytes)
+		clearBytes(inputBytes)
+	}
+
+	seed, err := parseTOTPSeed(input)
+	if err != nil {
+		return err
+	}
+
+	if err := client.SetMFASeed(profile, seed); err != nil {
+		return fmt.Errorf("failed to store MFA seed: %w", err)
+	}
+
+	fmt.Printf("✓ Stored MFA seed for profile '%s'\n", profile)
+
+	// IAM asks for two consecutive codes when a virtual MFA device is assigned
+	now := time.Now()
+	current, _ := totpCode(seed, now)
+	next, _ := totpCode(seed, now.Add(totpPeriod))
+	fmt.Printf("Current codes: %s, then %s\n", current, next)
+
+	configSettings, err := getConfigSettings(profile)
+	if err == nil && configSettings.MFASerial == "" {
+		fmt.Printf("\nTip: Codes are only used once mfa_serial is set:\n")
+		fmt.Printf("  caws config set %s mfa_serial arn:aws:iam::ACCOUNT:mfa/USERNAME\n", profile)
+	}
+
+	return nil
+}
+
+// handleMFARemoveSeed deletes the stored TOTP secret of a profile
+func handleMFARemoveSeed(profile string) error {
+	// Validate profile name
+	if err := validateProfileName(profile); err != nil {
+		return err
+	}
+
+	client, err := openCredentialStore()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if err := client.SetMFASeed(profile, ""); err != nil {
+		return fmt.Errorf("failed to remove MFA seed: %w", err)
+	}
+
+	fmt.Printf("✓ Removed MFA seed of profile '%s'; MFA codes will be prompted for\n", profile)
 
 	return nil
 }
@@ -291,7 +648,7 @@ func handleRemove(profile string) error {
 		return err
 	}
 
-	client, err := NewVaultClient()
+	client, err := openCredentialStore()
 	if err != nil {
 		return err
 	}
@@ -310,71 +667,421 @@ func handleRemove(profile string) error {
 	fmt.Printf("✓ Successfully removed profile '%s'\n", profile)
 
 	// Remove cached credentials
-	cacheDir := getCacheDir()
-	cachePath := fmt.Sprintf("%s/%s.json", cacheDir, profile)
-	os.Remove(cachePath) // Ignore errors
+	removeCachedCredentials(profile) // Ignore errors
 
 	return nil
 }
 
-// handleLogin handles generating an AWS Console login URL
-func handleLogin(profile string) error {
+// handleConfigShow handles printing the ~/.aws/config settings of a profile
+func handleConfigShow(profile string) error {
 	// Validate profile name
 	if err := validateProfileName(profile); err != nil {
 		return err
 	}
 
-	// Check for cached credentials FIRST (before prompting for password)
-	stsCreds, err := GetCachedCredentials(profile)
-	if err != nil || stsCreds.Type != "federation" {
-		// Cache miss, expired, or wrong type - need to get fresh credentials from vault
-		client, err := NewVaultClient()
-		if err != nil {
-			return err
+	config, err := loadAWSConfig()
+	if err != nil {
+		return fmt.Errorf("failed to read ~/.aws/config: %w", err)
+	}
+
+	section := profileSection(profile)
+	if !config.hasSection(section) {
+		return fmt.Errorf("profile '%s' not found in ~/.aws/config", profile)
+	}
+
+	// Parents of sub-keys are shown through their "parent.child" keys
+	keys := config.keys(section)
+	parents := make(map[string]bool)
+	for _, key := range keys {
+		if parent, _, isSubKey := strings.Cut(key, "."); isSubKey {
+			parents[parent] = true
 		}
-		defer client.Close()
+	}
 
-		// Get credentials from vault
-		creds, err := client.GetCredentials(profile)
-		if err != nil {
-			return fmt.Errorf("failed to get profile '%s': %w\nRun 'caws list' to see available profiles", profile, err)
+	values := config.values(section)
+	fmt.Printf("[%s]\n", section)
+	for _, key := range keys {
+		if !parents[key] {
+			fmt.Printf("%s = %s\n", key, values[key])
 		}
+	}
 
-		// Get region and MFA from ~/.aws/config
-		configSettings, err := getConfigSettings(profile)
-		if err != nil {
-			return fmt.Errorf("failed to read ~/.aws/config: %w", err)
+	return nil
+}
+
+// handleConfigGet handles printing a single ~/.aws/config setting of a profile.
+// Only the value is written to stdout, for use in scripts
+func handleConfigGet(profile, key string) error {
+	// Validate profile name
+	if err := validateProfileName(profile); err != nil {
+		return err
+	}
+
+	config, err := loadAWSConfig()
+	if err != nil {
+		return fmt.Errorf("failed to read ~/.aws/config: %w", err)
+	}
+
+	value, ok := config.get(profileSection(profile), key)
+	if !ok {
+		return fmt.Errorf("%s is not set for profile '%s'", key, profile)
+	}
+
+	fmt.Println(value)
+	return nil
+}
+
+// handleConfigSet handles setting a ~/.aws/config value of a profile,
+// creating the profile section if needed
+func handleConfigSet(profile, key, value string) error {
+	// Validate profile name
+	if err := validateProfileName(profile); err != nil {
+		return err
+	}
+	if err := validateConfigKey(key); err != nil {
+		return err
+	}
+	if err := validateConfigValue(key, value); err != nil {
+		return err
+	}
+
+	config, err := loadAWSConfig()
+	if err != nil {
+		return fmt.Errorf("failed to read ~/.aws/config: %w", err)
+	}
+
+	section := profileSection(profile)
+	created := !config.hasSection(section)
+	config.set(section, key, value)
+
+	if err := saveAWSConfig(config); err != nil {
+		return err
+	}
+
+	if created {
+		fmt.Printf("✓ Created [%s] in ~/.aws/config\n", section)
+	}
+	fmt.Printf("✓ Set %s = %s for profile '%s'\n", key, value, profile)
+
+	return nil
+}
+
+// handleConfigUnset handles removing a ~/.aws/config setting of a profile
+func handleConfigUnset(profile, key string) error {
+	// Validate profile name
+	if err := validateProfileName(profile); err != nil {
+		return err
+	}
+
+	config, err := loadAWSConfig()
+	if err != nil {
+		return fmt.Errorf("failed to read ~/.aws/config: %w", err)
+	}
+
+	if !config.unset(profileSection(profile), key) {
+		return fmt.Errorf("%s is not set for profile '%s'", key, profile)
+	}
+
+	if err := saveAWSConfig(config); err != nil {
+		return err
+	}
+
+	fmt.Printf("✓ Removed %s from profile '%s'\n", key, profile)
+
+	return nil
+}
+
+// handleRotate handles replacing a profile's IAM access key with a new one
+func handleRotate(profile string) error {
+	// Validate profile name
+	if err := validateProfileName(profile); err != nil {
+		return err
+	}
+
+	client, err := openCredentialStore()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	creds, err := client.GetCredentials(profile)
+	if err != nil {
+		return fmt.Errorf("failed to get profile '%s': %w\nRun 'caws list' to see available profiles", profile, err)
+	}
+
+	configSettings, err := getConfigSettings(profile)
+	if err != nil {
+		return fmt.Errorf("failed to read ~/.aws/config: %w", err)
+	}
+	creds.Region = resolveRegion(configSettings)
+
+	if !readConfirmation(fmt.Sprintf("Replace access key %s of profile '%s' and delete it from IAM? (yes/no): ", creds.AccessKeyID, profile)) {
+		fmt.Println("Cancelled")
+		return nil
+	}
+
+	newCreds, err := rotateAccessKey(client, profile, creds)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("✓ Rotated access key of profile '%s' (now %s)\n", profile, newCreds.AccessKeyID)
+
+	// Cached sessions were issued to the deleted key
+	removeCachedCredentials(profile) // Ignore errors
+
+	return nil
+}
+
+// handleCacheList handles listing cached credentials and when they expire
+func handleCacheList() error {
+	entries, err := listCachedCredentials("")
+	if err != nil {
+		return err
+	}
+
+	if len(entries) == 0 {
+		fmt.Println("No cached credentials")
+		return nil
+	}
+
+	fmt.Println("Cached credentials:")
+	for _, entry := range entries {
+		fmt.Printf("  • %s: %s\n", entry.profile, describeCacheEntry(entry))
+	}
+
+	return nil
+}
+
+// describeCacheEntry describes an entry and its expiry countdown for cache list
+func describeCacheEntry(entry cachedCredentials) string {
+	if entry.creds == nil {
+		return fmt.Sprintf("%s, unreadable (the cache key was replaced or removed)", entry.name)
+	}
+
+	creds := entry.creds
+	description := creds.Type
+	switch {
+	case creds.RoleARN != "":
+		description += " " + creds.RoleARN
+	case creds.SSORole != "":
+		description += " " + creds.SSORole
+	case creds.MFASerial != "":
+		description += " with MFA"
+	}
+	if creds.PolicyHash != "" {
+		description += ", policy " + creds.PolicyHash
+	}
+
+	remaining := time.Until(creds.Expiration)
+	if entry.expired() {
+		if remaining > 0 {
+			return fmt.Sprintf("%s, expires in %s (refreshed on next use)", description, remaining.Round(time.Second))
 		}
+		return fmt.Sprintf("%s, expired %s ago", description, (-remaining).Round(time.Second))
+	}
 
-		// Set region (default to us-east-1 if not configured)
-		if configSettings.Region != "" {
-			creds.Region = configSettings.Region
-		} else {
-			creds.Region = "us-east-1"
+	return fmt.Sprintf("%s, expires in %s (%s)", description, remaining.Round(time.Second), creds.Expiration.Local().Format("15:04:05"))
+}
+
+// handleCacheClear handles removing the cached credentials of a profile, or
+// of all profiles if profile is empty
+func handleCacheClear(profile string) error {
+	if profile != "" {
+		// Validate profile name
+		if err := validateProfileName(profile); err != nil {
+			return err
 		}
+	}
 
-		// Get federation token for console login (12 hour duration)
-		// Note: GetFederationToken doesn't support MFA parameter, but the base
-		// credentials are still protected by MFA if configured
-		stsCreds, err = GetFederationToken(creds, 43200, profile)
-		if err != nil {
-			return fmt.Errorf("failed to get federation token: %w", err)
+	entries, err := listCachedCredentials(profile)
+	if err != nil {
+		return err
+	}
+
+	cleared := make(map[string]bool)
+	for _, entry := range entries {
+		if cleared[entry.profile] {
+			continue
+		}
+		if err := removeCachedCredentials(entry.profile); err != nil {
+			return err
 		}
+		cleared[entry.profile] = true
+	}
+
+	switch {
+	case len(entries) == 0 && profile != "":
+		fmt.Printf("No cached credentials for profile '%s'\n", profile)
+	case len(entries) == 0:
+		fmt.Println("No cached credentials")
+	case profile != "":
+		fmt.Printf("✓ Cleared %d cached credential(s) of profile '%s'\n", len(entries), profile)
+	default:
+		fmt.Printf("✓ Cleared %d cached credential(s)\n", len(entries))
+	}
 
-		// Cache them
-		if err := CacheCredentials(profile, stsCreds); err != nil {
-			fmt.Fprintf(os.Stderr, "Warning: failed to cache credentials: %v\n", err)
+	return nil
+}
+
+// handleCachePrune handles removing expired and unreadable cached credentials
+func handleCachePrune() error {
+	entries, err := listCachedCredentials("")
+	if err != nil {
+		return err
+	}
+
+	pruned := 0
+	for _, entry := range entries {
+		if !entry.expired() {
+			continue
+		}
+		if err := os.Remove(entry.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to remove cached credentials: %w", err)
+		}
+		if entry.name != "" {
+			// Fails while the profile has other entries
+			os.Remove(filepath.Dir(entry.path))
+		}
+		pruned++
+	}
+
+	if pruned == 0 {
+		fmt.Println("No expired cached credentials")
+		return nil
+	}
+
+	fmt.Printf("✓ Removed %d expired cached credential(s)\n", pruned)
+
+	return nil
+}
+
+// loginOptions holds the flags of the login command
+type loginOptions struct {
+	// Requested console session lifetime, 0 for the profile's default
+	duration time.Duration
+
+	// Console page to land on; empty for the profile's console_service,
+	// console_path and region settings
+	service string
+	path    string
+	region  string
+
+	// Launch the system browser instead of printing the URL
+	open bool
+
+	// Session policies that down-scope the console session
+	policy policyFlags
+
+	// MFA code to use instead of prompting
+	mfaToken string
+}
+
+// handleLogin handles generating an AWS Console login URL
+func handleLogin(profile string, opts loginOptions) error {
+	// Validate profile name
+	if err := validateProfileName(profile); err != nil {
+		return err
+	}
+
+	duration, err := durationSeconds(opts.duration)
+	if err != nil {
+		return err
+	}
+
+	policy, err := opts.policy.load()
+	if err != nil {
+		return err
+	}
+	if opts.mfaToken != "" && !mfaCodePattern.MatchString(opts.mfaToken) {
+		return fmt.Errorf("invalid --mfa-token %q (expected 6 digits)", opts.mfaToken)
+	}
+
+	if opts.region != "" && !regionPattern.MatchString(opts.region) {
+		return fmt.Errorf("invalid region: %s (expected a region such as us-east-1)", opts.region)
+	}
+
+	// Get region, console destination and session lifetime from ~/.aws/config
+	configSettings, err := getConfigSettings(profile)
+	if err != nil {
+		return fmt.Errorf("failed to read ~/.aws/config: %w", err)
+	}
+
+	// Flags replace the configured destination as a whole
+	service, path := opts.service, opts.path
+	if service == "" && path == "" {
+		service, path = configSettings.ConsoleService, configSettings.ConsolePath
+	}
+	region := opts.region
+	if region == "" {
+		region = configSettings.Region
+	}
+	destination, err := consoleDestination(service, path, region)
+	if err != nil {
+		return err
+	}
+
+	duration, err = resolveDuration(profile, configSettings.ConsoleDurationSeconds, duration, defaultFederationDuration, minFederationDuration, maxFederationDuration)
+	if err != nil {
+		return err
+	}
+
+	vault := &vaultSession{}
+	defer vault.Close()
+
+	var stsCreds *STSCredentials
+	var sessionDuration int32
+	switch {
+	case configSettings.RoleARN != "" || configSettings.usesSSO():
+		// Role sessions come from the same chain, MFA prompts and cache as
+		// exec; the sign-in token sets the console session lifetime
+		stsCreds, err = getSessionCredentials(profile, sessionOptions{policy: policy, mfaToken: opts.mfaToken}, vault)
+		if err != nil {
+			return err
+		}
+		sessionDuration = duration
+	case configSettings.MFASerial != "":
+		// GetFederationToken cannot require MFA, and session credentials
+		// from GetSessionToken are not accepted for console sign-in
+		return fmt.Errorf("profile '%s' has mfa_serial but no role_arn: console sessions can only require MFA through a role\nSet role_arn and source_profile to sign in through an assumed role", profile)
+	default:
+		stsCreds, err = getFederationCredentials(profile, configSettings, duration, policy, vault)
+		if err != nil {
+			return err
 		}
 	}
 
 	// Generate console URL
-	consoleURL, err := GetConsoleURL(stsCreds, stsCreds.Region)
+	consoleURL, err := GetConsoleURL(stsCreds, destination, sessionDuration)
 	if err != nil {
 		return fmt.Errorf("failed to generate console URL: %w", err)
 	}
 
+	if opts.open {
+		if err := openBrowser(consoleURL); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "✓ Opened AWS Console for '%s' in your browser\n", profile)
+		return nil
+	}
+
 	// Print ONLY the URL to stdout (for piping to pbcopy, etc.)
 	fmt.Println(consoleURL)
 
 	return nil
 }
+
+// openBrowser opens a URL with the desktop's default browser
+func openBrowser(target string) error {
+	opener := "xdg-open"
+	if runtime.GOOS == "darwin" {
+		opener = "open"
+	}
+
+	cmd := exec.Command(opener, target)
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to open browser with %s: %w", opener, err)
+	}
+
+	return nil
+}
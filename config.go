@@ -1,10 +1,10 @@
 package main
 
 import (
-	"bufio"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 )
 
@@ -23,43 +23,34 @@ func getAWSConfigPath() (string, error) {
 	return filepath.Join(homeDir, ".aws", "config"), nil
 }
 
-// profileExistsInConfig checks if a profile exists in ~/.aws/config
-func profileExistsInConfig(profile string) (bool, error) {
-	configPath, err := getAWSConfigPath()
-	if err != nil {
-		return false, err
-	}
-
-	// If config file doesn't exist, profile doesn't exist
-	if _, err := os.Stat(configPath); os.IsNotExist(err) {
-		return false, nil
+// profileSection returns the ~/.aws/config section name of a profile
+func profileSection(profile string) string {
+	if profile == "default" {
+		return "default"
 	}
+	return "profile " + profile
+}
 
-	file, err := os.Open(configPath)
+// loadAWSConfig parses ~/.aws/config; a missing file is an empty document
+func loadAWSConfig() (*iniFile, error) {
+	configPath, err := getAWSConfigPath()
 	if err != nil {
-		return false, err
+		return nil, err
 	}
-	defer file.Close()
 
-	// Look for [profile <name>] or [default] (if profile is "default")
-	targetSection := fmt.Sprintf("[profile %s]", profile)
-	if profile == "default" {
-		targetSection = "[default]"
+	content, err := os.ReadFile(configPath)
+	if os.IsNotExist(err) {
+		return parseINI(""), nil
 	}
-
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		if line == targetSection {
-			return true, nil
-		}
+	if err != nil {
+		return nil, err
 	}
 
-	return false, scanner.Err()
+	return parseINI(string(content)), nil
 }
 
-// createConfigProfile creates a minimal profile section in ~/.aws/config
-func createConfigProfile(profile string) error {
+// saveAWSConfig atomically replaces ~/.aws/config, keeping its permissions
+func saveAWSConfig(config *iniFile) error {
 	configPath, err := getAWSConfigPath()
 	if err != nil {
 		return err
@@ -71,115 +62,181 @@ func createConfigProfile(profile string) error {
 		return fmt.Errorf("failed to create ~/.aws directory: %w", err)
 	}
 
-	// Determine section name
-	sectionName := fmt.Sprintf("[profile %s]", profile)
-	if profile == "default" {
-		sectionName = "[default]"
+	perm := os.FileMode(0600)
+	if info, err := os.Stat(configPath); err == nil {
+		perm = info.Mode().Perm()
 	}
 
-	// Open file for append (create if doesn't exist)
-	file, err := os.OpenFile(configPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
-	if err != nil {
-		return fmt.Errorf("failed to open config file: %w", err)
+	tempPath := configPath + ".tmp"
+	if err := os.WriteFile(tempPath, []byte(config.String()), perm); err != nil {
+		return fmt.Errorf("failed to write config file: %w", err)
 	}
-	defer file.Close()
-
-	// Check if file is empty or ends with newline
-	stat, err := file.Stat()
-	if err != nil {
-		return err
+	if err := os.Rename(tempPath, configPath); err != nil {
+		os.Remove(tempPath)
+		return fmt.Errorf("failed to replace config file: %w", err)
 	}
 
-	content := ""
-	if stat.Size() > 0 {
-		// File has content, ensure it ends with newline
-		content = "\n"
+	return nil
+}
+
+// profileExistsInConfig checks if a profile exists in ~/.aws/config
+func profileExistsInConfig(profile string) (bool, error) {
+	config, err := loadAWSConfig()
+	if err != nil {
+		return false, err
 	}
 
-	// Append profile section
-	content += fmt.Sprintf("%s\n", sectionName)
+	return config.hasSection(profileSection(profile)), nil
+}
 
-	if _, err := file.WriteString(content); err != nil {
-		return fmt.Errorf("failed to write to config file: %w", err)
+// createConfigProfile creates a minimal profile section in ~/.aws/config
+func createConfigProfile(profile string) error {
+	config, err := loadAWSConfig()
+	if err != nil {
+		return err
 	}
 
-	return nil
+	config.addSection(profileSection(profile))
+
+	return saveAWSConfig(config)
 }
 
 // ConfigSettings represents settings read from ~/.aws/config
 type ConfigSettings struct {
-	Region    string
-	MFASerial string
+	Values map[string]string // every key of the profile section
+
+	Region     string
+	MFASerial  string
+	MFAProcess string // shell command printing an MFA code
+
+	// Role assumption settings
+	RoleARN         string
+	SourceProfile   string
+	RoleSessionName string
+	DurationSeconds int32 // 0 if not configured
+
+	// Console login settings. The lifetime is 0 if not configured
+	ConsoleDurationSeconds int32
+	ConsoleService         string
+	ConsolePath            string
+
+	// IAM Identity Center settings. sso_start_url, sso_region and
+	// sso_registration_scopes may also come from an [sso-session] section
+	SSOSession            string
+	SSOStartURL           string
+	SSORegion             string
+	SSOAccountID          string
+	SSORoleName           string
+	SSORegistrationScopes []string
 }
 
-// getConfigSettings reads region and mfa_serial from ~/.aws/config for a profile
-func getConfigSettings(profile string) (*ConfigSettings, error) {
-	configPath, err := getAWSConfigPath()
-	if err != nil {
-		return nil, err
-	}
-
-	// If config file doesn't exist, return empty settings (no error)
-	if _, err := os.Stat(configPath); os.IsNotExist(err) {
-		return &ConfigSettings{}, nil
-	}
+// usesSSO reports whether the profile gets credentials from IAM Identity Center
+func (s *ConfigSettings) usesSSO() bool {
+	return s.SSOAccountID != "" || s.SSORoleName != "" || s.SSOSession != "" || s.SSOStartURL != ""
+}
 
-	file, err := os.Open(configPath)
+// getConfigSettings reads region, mfa_serial, role and SSO settings from ~/.aws/config for a profile
+func getConfigSettings(profile string) (*ConfigSettings, error) {
+	config, err := loadAWSConfig()
 	if err != nil {
 		return nil, err
 	}
-	defer file.Close()
 
-	// Look for the profile section
-	targetSection := fmt.Sprintf("[profile %s]", profile)
-	if profile == "default" {
-		targetSection = "[default]"
+	settings := &ConfigSettings{Values: config.values(profileSection(profile))}
+
+	for key, value := range settings.Values {
+		switch key {
+		case "region":
+			settings.Region = value
+		case "mfa_serial":
+			settings.MFASerial = value
+		case "mfa_process":
+			settings.MFAProcess = value
+		case "role_arn":
+			settings.RoleARN = value
+		case "source_profile":
+			settings.SourceProfile = value
+		case "role_session_name":
+			settings.RoleSessionName = value
+		case "duration_seconds":
+			seconds, err := strconv.ParseInt(value, 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("invalid duration_seconds for profile '%s': %s", profile, value)
+			}
+			settings.DurationSeconds = int32(seconds)
+		case "console_duration_seconds":
+			seconds, err := strconv.ParseInt(value, 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("invalid console_duration_seconds for profile '%s': %s", profile, value)
+			}
+			settings.ConsoleDurationSeconds = int32(seconds)
+		case "console_service":
+			settings.ConsoleService = value
+		case "console_path":
+			settings.ConsolePath = value
+		case "sso_session":
+			settings.SSOSession = value
+		case "sso_start_url":
+			settings.SSOStartURL = value
+		case "sso_region":
+			settings.SSORegion = value
+		case "sso_account_id":
+			settings.SSOAccountID = value
+		case "sso_role_name":
+			settings.SSORoleName = value
+		case "sso_registration_scopes":
+			settings.SSORegistrationScopes = splitScopes(value)
+		}
 	}
 
-	settings := &ConfigSettings{}
-	inTargetSection := false
-
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-
-		// Skip empty lines and comments
-		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
-			continue
+	if settings.SSOSession != "" {
+		if err := applySSOSession(config, settings); err != nil {
+			return nil, err
 		}
+	}
 
-		// Check if we entered our target section
-		if line == targetSection {
-			inTargetSection = true
-			continue
-		}
+	return settings, nil
+}
 
-		// Check if we entered a different section (stop reading)
-		if strings.HasPrefix(line, "[") {
-			inTargetSection = false
-			continue
+// applySSOSession fills in the settings of the [sso-session] section a profile refers to
+func applySSOSession(config *iniFile, settings *ConfigSettings) error {
+	section := "sso-session " + settings.SSOSession
+	if !config.hasSection(section) {
+		return fmt.Errorf("sso_session '%s' not found in ~/.aws/config", settings.SSOSession)
+	}
+	values := config.values(section)
+
+	// The session's start URL and region must agree with any set on the profile
+	for _, setting := range []struct {
+		key   string
+		value *string
+	}{
+		{"sso_start_url", &settings.SSOStartURL},
+		{"sso_region", &settings.SSORegion},
+	} {
+		sessionValue := values[setting.key]
+		if *setting.value != "" && sessionValue != "" && *setting.value != sessionValue {
+			return fmt.Errorf("%s of sso_session '%s' conflicts with the profile's %s", setting.key, settings.SSOSession, setting.key)
 		}
-
-		// If we're in our target section, parse key-value pairs
-		if inTargetSection {
-			parts := strings.SplitN(line, "=", 2)
-			if len(parts) == 2 {
-				key := strings.TrimSpace(parts[0])
-				value := strings.TrimSpace(parts[1])
-
-				switch key {
-				case "region":
-					settings.Region = value
-				case "mfa_serial":
-					settings.MFASerial = value
-				}
-			}
+		if sessionValue != "" {
+			*setting.value = sessionValue
 		}
 	}
 
-	if err := scanner.Err(); err != nil {
-		return nil, err
+	if scopes, ok := values["sso_registration_scopes"]; ok {
+		settings.SSORegistrationScopes = splitScopes(scopes)
 	}
 
-	return settings, nil
+	return nil
+}
+
+// splitScopes parses a comma-separated sso_registration_scopes value
+func splitScopes(value string) []string {
+	var scopes []string
+	for _, scope := range strings.Split(value, ",") {
+		if scope = strings.TrimSpace(scope); scope != "" {
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes
 }
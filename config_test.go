@@ -254,6 +254,183 @@ mfa_serial = arn:aws:iam::123456789012:mfa/user
 	}
 }
 
+func TestGetConfigSettingsRole(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config")
+
+	configContent := `[profile base]
+region = us-east-1
+
+[profile admin]
+role_arn = arn:aws:iam::210987654321:role/Admin
+source_profile = base
+role_session_name = alice
+duration_seconds = 7200
+
+[profile broken]
+role_arn = arn:aws:iam::210987654321:role/Admin
+duration_seconds = soon
+`
+
+	if err := os.WriteFile(configPath, []byte(configContent), 0600); err != nil {
+		t.Fatalf("failed to create test config: %v", err)
+	}
+
+	oldTestDir := os.Getenv("CAWS_TEST_DIR")
+	os.Setenv("CAWS_TEST_DIR", tmpDir)
+	defer os.Setenv("CAWS_TEST_DIR", oldTestDir)
+
+	settings, err := getConfigSettings("admin")
+	if err != nil {
+		t.Fatalf("getConfigSettings failed: %v", err)
+	}
+
+	if settings.RoleARN != "arn:aws:iam::210987654321:role/Admin" {
+		t.Errorf("role_arn: got %q", settings.RoleARN)
+	}
+	if settings.SourceProfile != "base" {
+		t.Errorf("source_profile: got %q, want %q", settings.SourceProfile, "base")
+	}
+	if settings.RoleSessionName != "alice" {
+		t.Errorf("role_session_name: got %q, want %q", settings.RoleSessionName, "alice")
+	}
+	if settings.DurationSeconds != 7200 {
+		t.Errorf("duration_seconds: got %d, want %d", settings.DurationSeconds, 7200)
+	}
+
+	if _, err := getConfigSettings("broken"); err == nil {
+		t.Error("expected error for invalid duration_seconds")
+	}
+}
+
+func TestGetConfigSettingsSSO(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config")
+
+	configContent := `[profile dev]
+sso_session = corp
+sso_account_id = 111122223333
+sso_role_name = Developer
+region = eu-west-1
+
+[sso-session corp]
+sso_start_url = https://corp.awsapps.com/start
+sso_region = eu-central-1
+sso_registration_scopes = sso:account:access, codewhisperer:completions
+
+[profile legacy]
+sso_start_url = https://legacy.awsapps.com/start
+sso_region = us-east-1
+sso_account_id = 444455556666
+sso_role_name = ReadOnly
+
+[profile missing]
+sso_session = nowhere
+sso_account_id = 111122223333
+sso_role_name = Developer
+
+[profile conflict]
+sso_session = corp
+sso_start_url = https://other.awsapps.com/start
+`
+
+	if err := os.WriteFile(configPath, []byte(configContent), 0600); err != nil {
+		t.Fatalf("failed to create test config: %v", err)
+	}
+
+	oldTestDir := os.Getenv("CAWS_TEST_DIR")
+	os.Setenv("CAWS_TEST_DIR", tmpDir)
+	defer os.Setenv("CAWS_TEST_DIR", oldTestDir)
+
+	settings, err := getConfigSettings("dev")
+	if err != nil {
+		t.Fatalf("getConfigSettings failed: %v", err)
+	}
+	if !settings.usesSSO() {
+		t.Error("expected dev to use SSO")
+	}
+	if settings.SSOStartURL != "https://corp.awsapps.com/start" {
+		t.Errorf("sso_start_url: got %q", settings.SSOStartURL)
+	}
+	if settings.SSORegion != "eu-central-1" {
+		t.Errorf("sso_region: got %q, want %q", settings.SSORegion, "eu-central-1")
+	}
+	if settings.Region != "eu-west-1" {
+		t.Errorf("region: got %q, want %q", settings.Region, "eu-west-1")
+	}
+	if settings.SSOAccountID != "111122223333" || settings.SSORoleName != "Developer" {
+		t.Errorf("sso account/role: got %q/%q", settings.SSOAccountID, settings.SSORoleName)
+	}
+	wantScopes := []string{"sso:account:access", "codewhisperer:completions"}
+	if len(settings.SSORegistrationScopes) != 2 || settings.SSORegistrationScopes[0] != wantScopes[0] || settings.SSORegistrationScopes[1] != wantScopes[1] {
+		t.Errorf("sso_registration_scopes: got %v, want %v", settings.SSORegistrationScopes, wantScopes)
+	}
+	if key := ssoSessionKey(settings); key != "corp" {
+		t.Errorf("session key: got %q, want %q", key, "corp")
+	}
+
+	legacy, err := getConfigSettings("legacy")
+	if err != nil {
+		t.Fatalf("getConfigSettings failed: %v", err)
+	}
+	if key := ssoSessionKey(legacy); key != "https://legacy.awsapps.com/start" {
+		t.Errorf("legacy session key: got %q", key)
+	}
+
+	if _, err := getConfigSettings("missing"); err == nil {
+		t.Error("expected error for unknown sso_session")
+	}
+	if _, err := getConfigSettings("conflict"); err == nil {
+		t.Error("expected error for conflicting sso_start_url")
+	}
+
+	base, err := getConfigSettings("nonexistent")
+	if err != nil {
+		t.Fatalf("getConfigSettings failed: %v", err)
+	}
+	if base.usesSSO() {
+		t.Error("profiles without sso settings should not use SSO")
+	}
+}
+
+func TestGetConfigSettingsValues(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config")
+
+	configContent := `[profile  dev]
+region = us-east-1 # primary region
+s3 =
+  max_concurrent_requests = 20
+
+[profile dev]
+mfa_serial = arn:aws:iam::123456789012:mfa/user
+`
+
+	if err := os.WriteFile(configPath, []byte(configContent), 0600); err != nil {
+		t.Fatalf("failed to create test config: %v", err)
+	}
+
+	oldTestDir := os.Getenv("CAWS_TEST_DIR")
+	os.Setenv("CAWS_TEST_DIR", tmpDir)
+	defer os.Setenv("CAWS_TEST_DIR", oldTestDir)
+
+	settings, err := getConfigSettings("dev")
+	if err != nil {
+		t.Fatalf("getConfigSettings failed: %v", err)
+	}
+
+	// Duplicate sections are merged and inline comments stripped
+	if settings.Region != "us-east-1" {
+		t.Errorf("region: got %q, want %q", settings.Region, "us-east-1")
+	}
+	if settings.MFASerial != "arn:aws:iam::123456789012:mfa/user" {
+		t.Errorf("mfa_serial: got %q", settings.MFASerial)
+	}
+	if got := settings.Values["s3.max_concurrent_requests"]; got != "20" {
+		t.Errorf("s3.max_concurrent_requests: got %q, want %q", got, "20")
+	}
+}
+
 // Helper function to check if a string contains a line
 func containsLine(content, line string) bool {
 	lines := splitLines(content)
@@ -0,0 +1,556 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Default and allowed lifetimes of GetSessionToken credentials used by exec
+	defaultSessionDuration = 3600
+	minSessionDuration     = 900
+	maxSessionDuration     = 129600
+
+	// Default and allowed lifetimes of AssumeRole credentials
+	defaultRoleDuration = 3600
+	minRoleDuration     = 900
+	maxRoleDuration     = 43200
+
+	// AWS limits roles assumed with the credentials of another role (role
+	// chaining) to one hour, whatever their maximum session duration
+	maxChainedRoleDuration = 3600
+
+	// Default and allowed lifetimes of GetFederationToken credentials used by login
+	defaultFederationDuration = 43200
+	minFederationDuration     = 900
+	maxFederationDuration     = 43200
+
+	// Maximum number of roles in a source_profile chain
+	maxRoleChainDepth = 5
+
+	// How long background refreshes wait after a failure
+	refreshRetryInterval = 30 * time.Second
+)
+
+// vaultSession opens the vault on first use, so that fully cached
+// credential lookups never prompt for the vault password
+type vaultSession struct {
+	client CredentialStore
+
+	// Long-term credentials already read, kept across Close so that
+	// long-running servers can refresh without prompting again
+	creds map[string]AWSCredentials
+}
+
+// GetCredentials returns the long-term credentials of a profile, opening the vault if needed
+func (s *vaultSession) GetCredentials(profile string) (*AWSCredentials, error) {
+	if creds, ok := s.creds[profile]; ok {
+		return &creds, nil
+	}
+
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+
+	creds, err := s.client.GetCredentials(profile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get profile '%s': %w\nRun 'caws list' to see available profiles", profile, err)
+	}
+
+	if s.creds == nil {
+		s.creds = make(map[string]AWSCredentials)
+	}
+	s.creds[profile] = *creds
+
+	return creds, nil
+}
+
+// GetSSOToken returns the stored IAM Identity Center token of a session, opening the vault if needed
+func (s *vaultSession) GetSSOToken(session string) (*SSOToken, error) {
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s.client.GetSSOToken(session)
+}
+
+// StoreSSOToken stores the IAM Identity Center token of a session, opening the vault if needed
+func (s *vaultSession) StoreSSOToken(session string, token *SSOToken) error {
+	if err := s.open(); err != nil {
+		return err
+	}
+	return s.client.StoreSSOToken(session, token)
+}
+
+// open opens the vault unless it is already open
+func (s *vaultSession) open() error {
+	if s.client != nil {
+		return nil
+	}
+
+	client, err := openCredentialStore()
+	if err != nil {
+		return err
+	}
+	s.client = client
+	return nil
+}
+
+// Close releases the vault if it was opened
+func (s *vaultSession) Close() error {
+	if s.client == nil {
+		return nil
+	}
+	err := s.client.Close()
+	s.client = nil
+	return err
+}
+
+// refreshingProvider hands out credentials for a profile to long-running
+// servers, refreshing them from the cache or vault before they expire
+type refreshingProvider struct {
+	profile string
+	opts    sessionOptions
+	vault   *vaultSession
+
+	mu    sync.Mutex
+	creds *STSCredentials
+}
+
+// newRefreshingProvider creates a provider for a profile
+func newRefreshingProvider(profile string, opts sessionOptions) *refreshingProvider {
+	return &refreshingProvider{
+		profile: profile,
+		opts:    opts,
+		vault:   &vaultSession{},
+	}
+}
+
+// Retrieve returns current credentials, refreshing them if they are about to expire
+func (p *refreshingProvider) Retrieve() (*STSCredentials, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.creds != nil && time.Now().Add(cacheExpiryBuffer).Before(p.creds.Expiration) {
+		return p.creds, nil
+	}
+
+	creds, err := getSessionCredentials(p.profile, p.opts, p.vault)
+	// Never hold the vault lock between refreshes
+	p.vault.Close()
+	// STS refuses an MFA code used before, so refreshes get new ones from the
+	// seed, mfa_process or a prompt
+	p.opts.mfaToken = ""
+	if err != nil {
+		return nil, err
+	}
+
+	p.creds = creds
+	return creds, nil
+}
+
+// RefreshLoop refreshes credentials in the background just before they
+// expire, so that requests never wait on STS. It returns when done is closed.
+func (p *refreshingProvider) RefreshLoop(done <-chan struct{}) {
+	for {
+		wait := refreshRetryInterval
+		if creds, err := p.Retrieve(); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to refresh credentials for '%s': %v\n", p.profile, err)
+		} else {
+			wait = time.Until(creds.Expiration.Add(-cacheExpiryBuffer))
+		}
+
+		select {
+		case <-done:
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// sessionOptions shapes the credentials requested for a profile. The
+// duration and policy apply to the profile itself, never to the source
+// profiles it is assumed from
+type sessionOptions struct {
+	duration int32          // overrides duration_seconds if non-zero
+	policy   *sessionPolicy // down-scopes the session if non-nil
+	mfaToken string         // MFA code for the base session, if one is needed
+}
+
+// getSessionCredentials returns cached or fresh temporary credentials for a
+// profile, assuming every IAM role along its source_profile chain
+func getSessionCredentials(profile string, opts sessionOptions, vault *vaultSession) (*STSCredentials, error) {
+	chain, err := resolveRoleChain(profile)
+	if err != nil {
+		return nil, err
+	}
+
+	// The first mfa_serial and mfa_process found walking down the chain
+	// protect the base session
+	mfa := mfaSource{token: opts.mfaToken}
+	for _, hop := range chain {
+		if mfa.serial == "" {
+			mfa.serial = hop.settings.MFASerial
+		}
+		if mfa.process == "" {
+			mfa.process = hop.settings.MFAProcess
+		}
+	}
+
+	return getChainCredentials(chain, mfa, opts, vault)
+}
+
+// roleHop is one profile of a source_profile chain
+type roleHop struct {
+	profile  string
+	settings *ConfigSettings
+}
+
+// resolveRoleChain follows source_profile links from profile down to the
+// profile holding long-term keys. The requested profile comes first.
+func resolveRoleChain(profile string) ([]roleHop, error) {
+	var chain []roleHop
+	visited := make(map[string]bool)
+	names := []string{}
+
+	for {
+		if visited[profile] {
+			return nil, fmt.Errorf("source_profile cycle detected: %s", strings.Join(append(names, profile), " -> "))
+		}
+		if len(chain) > maxRoleChainDepth {
+			return nil, fmt.Errorf("source_profile chain is too deep (more than %d roles): %s", maxRoleChainDepth, strings.Join(names, " -> "))
+		}
+		visited[profile] = true
+		names = append(names, profile)
+
+		configSettings, err := getConfigSettings(profile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read ~/.aws/config: %w", err)
+		}
+		chain = append(chain, roleHop{profile: profile, settings: configSettings})
+
+		if configSettings.RoleARN == "" {
+			return chain, nil
+		}
+
+		if configSettings.SourceProfile == "" {
+			return nil, fmt.Errorf("profile '%s' has role_arn but no source_profile", profile)
+		}
+		if err := validateProfileName(configSettings.SourceProfile); err != nil {
+			return nil, fmt.Errorf("invalid source_profile for profile '%s': %w", profile, err)
+		}
+
+		profile = configSettings.SourceProfile
+	}
+}
+
+// getChainCredentials returns credentials for the first hop of a chain,
+// refreshing parent hops only when their own cached credentials are gone.
+// The options apply to the first hop only
+func getChainCredentials(chain []roleHop, mfa mfaSource, opts sessionOptions, vault *vaultSession) (*STSCredentials, error) {
+	hop := chain[0]
+	if hop.settings.RoleARN == "" {
+		if hop.settings.usesSSO() {
+			if opts.policy != nil {
+				return nil, fmt.Errorf("profile '%s' uses IAM Identity Center, whose role credentials cannot be down-scoped with session policies", hop.profile)
+			}
+			// IAM Identity Center decides the lifetime of role credentials
+			return getSSOCredentials(hop.profile, hop.settings, vault)
+		}
+		if opts.policy != nil {
+			// GetSessionToken does not accept session policies
+			if mfa.serial != "" {
+				return nil, fmt.Errorf("profile '%s' has mfa_serial but no role_arn: session policies need a role to keep requiring MFA", hop.profile)
+			}
+			// Federation tokens last at most 12 hours; exec keeps its one-hour default
+			duration, err := resolveDuration(hop.profile, hop.settings.DurationSeconds, opts.duration, defaultSessionDuration, minFederationDuration, maxFederationDuration)
+			if err != nil {
+				return nil, err
+			}
+			return getFederationCredentials(hop.profile, hop.settings, duration, opts.policy, vault)
+		}
+		return getBaseSessionCredentials(hop.profile, hop.settings, mfa, opts.duration, vault)
+	}
+
+	// IAM Identity Center credentials are role credentials as well
+	chained := chain[1].settings.RoleARN != "" || chain[1].settings.usesSSO()
+	maxDuration := int32(maxRoleDuration)
+	if chained {
+		maxDuration = maxChainedRoleDuration
+	}
+	duration, err := resolveDuration(hop.profile, hop.settings.DurationSeconds, opts.duration, defaultRoleDuration, minRoleDuration, maxDuration)
+	if err != nil {
+		if chained {
+			return nil, fmt.Errorf("%w (AWS limits roles assumed from another role to 1h)", err)
+		}
+		return nil, err
+	}
+
+	// Cached role credentials are only valid for the role, lifetime and policies currently requested
+	stsCreds, err := GetCachedCredentials(hop.profile, cacheEntry{Type: "role", Role: hop.settings.RoleARN, Policy: opts.policy.hash()})
+	if err == nil && stsCreds.Duration == duration {
+		fmt.Fprintf(os.Stderr, "Using cached credentials for '%s' (valid until %s)\n", hop.profile, stsCreds.Expiration.Format("15:04:05"))
+		return stsCreds, nil
+	}
+
+	sessionName := hop.settings.RoleSessionName
+	if sessionName == "" {
+		sessionName = fmt.Sprintf("caws-%d", time.Now().Unix())
+	}
+
+	sourceCreds, err := getChainCredentials(chain[1:], mfa, sessionOptions{}, vault)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Fprintf(os.Stderr, "Assuming role %s...\n", hop.settings.RoleARN)
+
+	stsCreds, err = AssumeIAMRole(sourceCreds, hop.settings.RoleARN, sessionName, duration, opts.policy)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get temporary credentials for '%s': %w", hop.profile, err)
+	}
+
+	// A hop's own region wins over the region inherited from its source
+	if hop.settings.Region != "" {
+		stsCreds.Region = hop.settings.Region
+	}
+
+	cacheAndReport(hop.profile, stsCreds)
+
+	return stsCreds, nil
+}
+
+// getBaseSessionCredentials returns cached or fresh GetSessionToken credentials
+// for a profile whose long-term keys are stored in the vault
+func getBaseSessionCredentials(profile string, configSettings *ConfigSettings, mfa mfaSource, duration int32, vault *vaultSession) (*STSCredentials, error) {
+	duration, err := resolveDuration(profile, configSettings.DurationSeconds, duration, defaultSessionDuration, minSessionDuration, maxSessionDuration)
+	if err != nil {
+		return nil, err
+	}
+
+	// Check for cached credentials FIRST (before prompting for password)
+	stsCreds, err := GetCachedCredentials(profile, cacheEntry{Type: "session"})
+	if err == nil && stsCreds.MFASerial == mfa.serial && stsCreds.Duration == duration {
+		fmt.Fprintf(os.Stderr, "Using cached credentials (valid until %s)\n", stsCreds.Expiration.Format("15:04:05"))
+		return stsCreds, nil
+	}
+
+	// Cache miss, expired, or wrong MFA device or lifetime - need to get fresh credentials from vault
+	creds, err := vault.GetCredentials(profile)
+	if err != nil {
+		return nil, err
+	}
+
+	creds.Region = resolveRegion(configSettings)
+	creds.MFASerial = mfa.serial
+
+	fmt.Fprintln(os.Stderr, "Getting temporary credentials...")
+
+	// Get temporary credentials, asking for a new MFA code if STS rejects one
+	for attempt := 1; ; attempt++ {
+		var mfaCode string
+		if creds.MFASerial != "" {
+			mfaCode, err = mfa.code(creds.MFASeed, attempt)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		stsCreds, err = AssumeRole(creds, duration, mfaCode)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, errMFARejected) || !mfa.retryable() || attempt == maxMFAAttempts {
+			return nil, fmt.Errorf("failed to get temporary credentials: %w", err)
+		}
+		fmt.Fprintln(os.Stderr, "MFA code was rejected, try again")
+	}
+
+	cacheAndReport(profile, stsCreds)
+
+	return stsCreds, nil
+}
+
+// getSSOCredentials returns cached or fresh IAM Identity Center role
+// credentials, signing in with the device authorization flow if the
+// session's token in the vault is missing or expired
+func getSSOCredentials(profile string, configSettings *ConfigSettings, vault *vaultSession) (*STSCredentials, error) {
+	for _, required := range []struct{ key, value string }{
+		{"sso_start_url", configSettings.SSOStartURL},
+		{"sso_region", configSettings.SSORegion},
+		{"sso_account_id", configSettings.SSOAccountID},
+		{"sso_role_name", configSettings.SSORoleName},
+	} {
+		if required.value == "" {
+			return nil, fmt.Errorf("profile '%s' uses IAM Identity Center but has no %s", profile, required.key)
+		}
+	}
+	ssoRole := configSettings.SSOAccountID + "/" + configSettings.SSORoleName
+
+	// Check for cached credentials FIRST (before prompting for password)
+	stsCreds, err := GetCachedCredentials(profile, cacheEntry{Type: "sso", Role: ssoRole})
+	if err == nil {
+		fmt.Fprintf(os.Stderr, "Using cached credentials (valid until %s)\n", stsCreds.Expiration.Format("15:04:05"))
+		return stsCreds, nil
+	}
+
+	session := ssoSessionKey(configSettings)
+	token, err := getSSOToken(session, configSettings, vault, false)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Fprintln(os.Stderr, "Getting role credentials from IAM Identity Center...")
+
+	stsCreds, err = GetSSORoleCredentials(token, configSettings.SSOAccountID, configSettings.SSORoleName)
+	if errors.Is(err, errSSOUnauthorized) {
+		// The token was revoked or the session ended early; sign in again
+		fmt.Fprintln(os.Stderr, "IAM Identity Center session is no longer valid")
+		token, err = getSSOToken(session, configSettings, vault, true)
+		if err != nil {
+			return nil, err
+		}
+		stsCreds, err = GetSSORoleCredentials(token, configSettings.SSOAccountID, configSettings.SSORoleName)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get temporary credentials: %w", err)
+	}
+
+	stsCreds.Region = resolveRegion(configSettings)
+
+	cacheAndReport(profile, stsCreds)
+
+	return stsCreds, nil
+}
+
+// getSSOToken returns a usable access token for an SSO session from the
+// vault, refreshing it or signing in again as needed
+func getSSOToken(session string, configSettings *ConfigSettings, vault *vaultSession, forceLogin bool) (*SSOToken, error) {
+	token, err := vault.GetSSOToken(session)
+	if err != nil {
+		return nil, err
+	}
+
+	// A token only belongs to the start URL it was issued for
+	if token != nil && (token.StartURL != configSettings.SSOStartURL || token.Region != configSettings.SSORegion) {
+		token = nil
+	}
+
+	if token != nil && !forceLogin {
+		if token.valid() {
+			return token, nil
+		}
+
+		if refreshed, err := RefreshSSOToken(token); err == nil {
+			if err := vault.StoreSSOToken(session, refreshed); err != nil {
+				return nil, fmt.Errorf("failed to store SSO token: %w", err)
+			}
+			return refreshed, nil
+		}
+	}
+
+	token, err = SSODeviceLogin(configSettings, token)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := vault.StoreSSOToken(session, token); err != nil {
+		return nil, fmt.Errorf("failed to store SSO token: %w", err)
+	}
+
+	return token, nil
+}
+
+// getFederationCredentials returns cached or fresh GetFederationToken
+// credentials for a profile whose long-term keys are stored in the vault
+func getFederationCredentials(profile string, configSettings *ConfigSettings, duration int32, policy *sessionPolicy, vault *vaultSession) (*STSCredentials, error) {
+	// Check for cached credentials FIRST (before prompting for password)
+	stsCreds, err := GetCachedCredentials(profile, cacheEntry{Type: "federation", Policy: policy.hash()})
+	if err == nil && stsCreds.Duration == duration {
+		fmt.Fprintf(os.Stderr, "Using cached credentials (valid until %s)\n", stsCreds.Expiration.Format("15:04:05"))
+		return stsCreds, nil
+	}
+
+	// Cache miss, expired, or wrong lifetime - need to get fresh credentials from vault
+	creds, err := vault.GetCredentials(profile)
+	if err != nil {
+		return nil, err
+	}
+
+	creds.Region = resolveRegion(configSettings)
+
+	fmt.Fprintln(os.Stderr, "Getting federation credentials...")
+
+	stsCreds, err = GetFederationToken(creds, duration, profile, policy)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get federation token: %w", err)
+	}
+
+	cacheAndReport(profile, stsCreds)
+
+	return stsCreds, nil
+}
+
+// resolveDuration returns the lifetime to request for a profile's
+// credentials: the override if set, else the configured duration, else the
+// default. The result must lie within the limits of the STS call
+func resolveDuration(profile string, configured, override, def, min, max int32) (int32, error) {
+	duration := override
+	if duration == 0 {
+		duration = configured
+	}
+	if duration == 0 {
+		duration = def
+	}
+
+	if duration < min || duration > max {
+		return 0, fmt.Errorf("session duration for profile '%s' must be between %s and %s, got %s",
+			profile, formatDuration(min), formatDuration(max), formatDuration(duration))
+	}
+
+	return duration, nil
+}
+
+// durationSeconds converts a --duration flag to whole seconds
+func durationSeconds(d time.Duration) (int32, error) {
+	if d < 0 || d%time.Second != 0 || d > math.MaxInt32*time.Second {
+		return 0, fmt.Errorf("invalid duration %s (expected whole seconds, e.g. 15m or 12h)", d)
+	}
+	return int32(d / time.Second), nil
+}
+
+// formatDuration formats a number of seconds for messages, e.g. "15m" or "36h"
+func formatDuration(seconds int32) string {
+	d := time.Duration(seconds) * time.Second
+	switch {
+	case d%time.Hour == 0:
+		return fmt.Sprintf("%dh", d/time.Hour)
+	case d%time.Minute == 0:
+		return fmt.Sprintf("%dm", d/time.Minute)
+	default:
+		return fmt.Sprintf("%ds", seconds)
+	}
+}
+
+// resolveRegion returns the configured region, defaulting to us-east-1
+func resolveRegion(configSettings *ConfigSettings) string {
+	if configSettings.Region != "" {
+		return configSettings.Region
+	}
+
+	fmt.Fprintln(os.Stderr, "⚠️  Warning: No region configured in ~/.aws/config, using us-east-1")
+	return "us-east-1"
+}
+
+// cacheAndReport caches fresh credentials, warning instead of failing on errors
+func cacheAndReport(profile string, stsCreds *STSCredentials) {
+	if err := CacheCredentials(profile, stsCreds); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to cache credentials: %v\n", err)
+	} else {
+		fmt.Fprintf(os.Stderr, "✓ Credentials cached (valid until %s)\n", stsCreds.Expiration.Format("15:04:05"))
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestResolveRoleChain(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config")
+
+	configContent := `[profile engineer]
+region = us-east-1
+mfa_serial = arn:aws:iam::123456789012:mfa/alice
+
+[profile shared]
+role_arn = arn:aws:iam::111111111111:role/Shared
+source_profile = engineer
+
+[profile workload]
+role_arn = arn:aws:iam::222222222222:role/Deploy
+source_profile = shared
+
+[profile loop-a]
+role_arn = arn:aws:iam::111111111111:role/A
+source_profile = loop-b
+
+[profile loop-b]
+role_arn = arn:aws:iam::111111111111:role/B
+source_profile = loop-a
+
+[profile orphan]
+role_arn = arn:aws:iam::111111111111:role/Orphan
+`
+
+	for i := 1; i <= 6; i++ {
+		source := "engineer"
+		if i > 1 {
+			source = fmt.Sprintf("deep%d", i-1)
+		}
+		configContent += fmt.Sprintf("\n[profile deep%d]\nrole_arn = arn:aws:iam::111111111111:role/Deep\nsource_profile = %s\n", i, source)
+	}
+
+	if err := os.WriteFile(configPath, []byte(configContent), 0600); err != nil {
+		t.Fatalf("failed to create test config: %v", err)
+	}
+
+	oldTestDir := os.Getenv("CAWS_TEST_DIR")
+	os.Setenv("CAWS_TEST_DIR", tmpDir)
+	defer os.Setenv("CAWS_TEST_DIR", oldTestDir)
+
+	t.Run("multi-hop chain", func(t *testing.T) {
+		chain, err := resolveRoleChain("workload")
+		if err != nil {
+			t.Fatalf("resolveRoleChain failed: %v", err)
+		}
+
+		want := []string{"workload", "shared", "engineer"}
+		if len(chain) != len(want) {
+			t.Fatalf("expected %d hops, got %d", len(want), len(chain))
+		}
+		for i, hop := range chain {
+			if hop.profile != want[i] {
+				t.Errorf("hop %d: got %q, want %q", i, hop.profile, want[i])
+			}
+		}
+	})
+
+	t.Run("base profile only", func(t *testing.T) {
+		chain, err := resolveRoleChain("engineer")
+		if err != nil {
+			t.Fatalf("resolveRoleChain failed: %v", err)
+		}
+		if len(chain) != 1 || chain[0].profile != "engineer" {
+			t.Errorf("expected single hop 'engineer', got %v", chain)
+		}
+	})
+
+	t.Run("maximum depth", func(t *testing.T) {
+		chain, err := resolveRoleChain("deep5")
+		if err != nil {
+			t.Fatalf("resolveRoleChain failed: %v", err)
+		}
+		if len(chain) != maxRoleChainDepth+1 {
+			t.Errorf("expected %d hops, got %d", maxRoleChainDepth+1, len(chain))
+		}
+	})
+
+	tests := []struct {
+		name    string
+		profile string
+		wantErr string
+	}{
+		{"cycle", "loop-a", "loop-a -> loop-b -> loop-a"},
+		{"too deep", "deep6", "too deep"},
+		{"missing source", "orphan", "has role_arn but no source_profile"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := resolveRoleChain(tt.profile)
+			if err == nil {
+				t.Fatal("expected error, got nil")
+			}
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error %q should contain %q", err.Error(), tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestResolveDuration(t *testing.T) {
+	tests := []struct {
+		name                 string
+		configured, override int32
+		want                 int32
+		wantErr              bool
+	}{
+		{name: "default", want: defaultSessionDuration},
+		{name: "configured", configured: 7200, want: 7200},
+		{name: "override wins", configured: 7200, override: 900, want: 900},
+		{name: "36 hours", override: 129600, want: 129600},
+		{name: "too short", override: 60, wantErr: true},
+		{name: "too long", configured: 200000, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := resolveDuration("dev", tt.configured, tt.override, defaultSessionDuration, minSessionDuration, maxSessionDuration)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got %d", got)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("resolveDuration = %d, %v; want %d", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestDownScopedSessionDuration(t *testing.T) {
+	t.Setenv("CAWS_TEST_DIR", t.TempDir())
+
+	// Plain profiles are down-scoped with GetFederationToken, which allows 12h
+	chain := []roleHop{{profile: "base", settings: &ConfigSettings{}}}
+	policy := &sessionPolicy{arns: []string{readOnlyPolicyARN}}
+	vault := &vaultSession{}
+
+	_, err := getChainCredentials(chain, mfaSource{}, sessionOptions{duration: 24 * 3600, policy: policy}, vault)
+	if err == nil || !strings.Contains(err.Error(), "between 15m and 12h") {
+		t.Errorf("expected a 24h down-scoped session to be rejected, got %v", err)
+	}
+}
@@ -14,41 +14,118 @@ import (
 
 // VaultFile represents the encrypted vault file structure
 type VaultFile struct {
-	Version int    `json:"version"`
-	Salt    string `json:"salt"`  // base64-encoded
-	Nonce   string `json:"nonce"` // base64-encoded
-	Data    string `json:"data"`  // base64-encoded encrypted JSON
+	Version int        `json:"version"`
+	KDF     *KDFParams `json:"kdf,omitempty"` // v2 only; v1 uses legacyKDFParams
+	Salt    string     `json:"salt"`          // base64-encoded
+	Nonce   string     `json:"nonce"`         // base64-encoded
+	Data    string     `json:"data"`          // base64-encoded encrypted JSON
+}
+
+// KDFParams describes how the vault key is derived from the password
+type KDFParams struct {
+	Algorithm string `json:"algorithm"`
+	Time      uint32 `json:"time"`
+	Memory    uint32 `json:"memory"` // KiB
+	Threads   uint8  `json:"threads"`
 }
 
 // VaultData represents the decrypted vault contents
 type VaultData struct {
-	Profiles map[string]ProfileData `json:"profiles"`
+	Profiles  map[string]ProfileData `json:"profiles"`
+	SSOTokens map[string]SSOToken    `json:"sso_tokens,omitempty"` // by sso_session name (or start URL)
 }
 
 // ProfileData represents stored AWS credentials for a profile
 type ProfileData struct {
 	AccessKey string `json:"access_key"`
 	SecretKey string `json:"secret_key"`
+	MFASeed   string `json:"mfa_seed,omitempty"` // base32 TOTP secret of the profile's virtual MFA device
 }
 
 const (
-	vaultVersion = 1
-	saltSize     = 32
-	nonceSize    = 12
-	keySize      = 32 // AES-256
+	vaultVersion   = 2 // Current format, with KDF parameters in the header
+	vaultVersionV1 = 1 // Original format, with fixed KDF parameters
+	saltSize       = 32
+	nonceSize      = 12
+	keySize        = 32 // AES-256
+
+	kdfArgon2id = "argon2id"
 
-	// Argon2id parameters (balanced security/performance)
+	// Argon2id parameters for new vaults (balanced security/performance)
 	argonTime    = 1
 	argonMemory  = 64 * 1024 // 64 MB
 	argonThreads = 4
+
+	// Upper bounds on KDF parameters read from a vault header, so a
+	// tampered file cannot make unlocking exhaust memory or CPU
+	maxArgonTime   = 64
+	maxArgonMemory = 4 * 1024 * 1024 // 4 GB
 )
 
-// deriveKey derives an encryption key from a password using Argon2id
+// legacyKDFParams are the parameters every v1 vault was encrypted with
+var legacyKDFParams = KDFParams{
+	Algorithm: kdfArgon2id,
+	Time:      1,
+	Memory:    64 * 1024,
+	Threads:   4,
+}
+
+// defaultKDFParams returns the parameters used for new and upgraded vaults
+func defaultKDFParams() KDFParams {
+	return KDFParams{
+		Algorithm: kdfArgon2id,
+		Time:      argonTime,
+		Memory:    argonMemory,
+		Threads:   argonThreads,
+	}
+}
+
+// validate checks that KDF parameters are supported and within bounds
+func (p KDFParams) validate() error {
+	if p.Algorithm != kdfArgon2id {
+		return fmt.Errorf("unsupported key derivation algorithm: %q", p.Algorithm)
+	}
+	if p.Time < 1 || p.Time > maxArgonTime {
+		return fmt.Errorf("invalid argon2id time parameter: %d", p.Time)
+	}
+	if p.Threads < 1 {
+		return fmt.Errorf("invalid argon2id threads parameter: %d", p.Threads)
+	}
+	if p.Memory < 8*uint32(p.Threads) || p.Memory > maxArgonMemory {
+		return fmt.Errorf("invalid argon2id memory parameter: %d KiB", p.Memory)
+	}
+	return nil
+}
+
+// deriveKey derives an encryption key from a password with these parameters
+func (p KDFParams) deriveKey(password string, salt []byte) []byte {
+	return argon2.IDKey([]byte(password), salt, p.Time, p.Memory, p.Threads, keySize)
+}
+
+// deriveKey derives an encryption key from a password using the default parameters
 func deriveKey(password string, salt []byte) []byte {
-	return argon2.IDKey([]byte(password), salt, argonTime, argonMemory, argonThreads, keySize)
+	return defaultKDFParams().deriveKey(password, salt)
+}
+
+// vaultKDFParams returns the parameters a vault file's key was derived with
+func vaultKDFParams(vaultFile *VaultFile) (KDFParams, error) {
+	switch vaultFile.Version {
+	case vaultVersionV1:
+		return legacyKDFParams, nil
+	case vaultVersion:
+		if vaultFile.KDF == nil {
+			return KDFParams{}, fmt.Errorf("vault version %d is missing KDF parameters", vaultFile.Version)
+		}
+		if err := vaultFile.KDF.validate(); err != nil {
+			return KDFParams{}, err
+		}
+		return *vaultFile.KDF, nil
+	default:
+		return KDFParams{}, fmt.Errorf("unsupported vault version: %d", vaultFile.Version)
+	}
 }
 
-// encryptVault encrypts vault data with a password
+// encryptVault encrypts vault data with a password into the current format
 func encryptVault(password string, data *VaultData) (*VaultFile, error) {
 	// Generate random salt
 	salt := make([]byte, saltSize)
@@ -57,8 +134,17 @@ func encryptVault(password string, data *VaultData) (*VaultFile, error) {
 	}
 
 	// Derive encryption key from password
-	key := deriveKey(password, salt)
+	kdf := defaultKDFParams()
+	key := kdf.deriveKey(password, salt)
+	defer clearBytes(key)
 
+	return encryptVaultWithKey(key, salt, &kdf, data)
+}
+
+// encryptVaultWithKey encrypts vault data with an already derived key.
+// The salt and KDF parameters are stored alongside so the key can be derived
+// again from the password. A nil kdf writes a v1 vault (legacy parameters)
+func encryptVaultWithKey(key, salt []byte, kdf *KDFParams, data *VaultData) (*VaultFile, error) {
 	// Marshal vault data to JSON
 	plaintext, err := json.Marshal(data)
 	if err != nil {
@@ -86,27 +172,47 @@ func encryptVault(password string, data *VaultData) (*VaultFile, error) {
 	// Encrypt and authenticate
 	ciphertext := gcm.Seal(nil, nonce, plaintext, nil)
 
+	version := vaultVersion
+	if kdf == nil {
+		version = vaultVersionV1
+	}
+
 	return &VaultFile{
-		Version: vaultVersion,
+		Version: version,
+		KDF:     kdf,
 		Salt:    base64.StdEncoding.EncodeToString(salt),
 		Nonce:   base64.StdEncoding.EncodeToString(nonce),
 		Data:    base64.StdEncoding.EncodeToString(ciphertext),
 	}, nil
 }
 
-// decryptVault decrypts a vault file with a password
+// decryptVault decrypts a vault file (any supported version) with a password
 func decryptVault(password string, vaultFile *VaultFile) (*VaultData, error) {
-	// Check version
-	if vaultFile.Version != vaultVersion {
-		return nil, fmt.Errorf("unsupported vault version: %d", vaultFile.Version)
+	kdf, err := vaultKDFParams(vaultFile)
+	if err != nil {
+		return nil, err
 	}
 
-	// Decode base64 fields
 	salt, err := base64.StdEncoding.DecodeString(vaultFile.Salt)
 	if err != nil {
 		return nil, fmt.Errorf("failed to decode salt: %w", err)
 	}
 
+	// Derive encryption key from password
+	key := kdf.deriveKey(password, salt)
+	defer clearBytes(key)
+
+	return decryptVaultWithKey(key, vaultFile)
+}
+
+// decryptVaultWithKey decrypts a vault file with an already derived key
+func decryptVaultWithKey(key []byte, vaultFile *VaultFile) (*VaultData, error) {
+	// Check version
+	if vaultFile.Version != vaultVersion && vaultFile.Version != vaultVersionV1 {
+		return nil, fmt.Errorf("unsupported vault version: %d", vaultFile.Version)
+	}
+
+	// Decode base64 fields
 	nonce, err := base64.StdEncoding.DecodeString(vaultFile.Nonce)
 	if err != nil {
 		return nil, fmt.Errorf("failed to decode nonce: %w", err)
@@ -117,9 +223,6 @@ func decryptVault(password string, vaultFile *VaultFile) (*VaultData, error) {
 		return nil, fmt.Errorf("failed to decode data: %w", err)
 	}
 
-	// Derive encryption key from password
-	key := deriveKey(password, salt)
-
 	// Create AES cipher
 	block, err := aes.NewCipher(key)
 	if err != nil {
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// ECSCredentials is the document served by the ECS container credentials
+// endpoint (AWS_CONTAINER_CREDENTIALS_FULL_URI)
+type ECSCredentials struct {
+	AccessKeyID     string `json:"AccessKeyId"`
+	SecretAccessKey string `json:"SecretAccessKey"`
+	Token           string `json:"Token"`
+	Expiration      string `json:"Expiration"`
+	RoleArn         string `json:"RoleArn,omitempty"`
+}
+
+// ecsHandler serves credentials to requests carrying the bearer token
+type ecsHandler struct {
+	token    string
+	retrieve func() (*STSCredentials, error)
+}
+
+// newECSHandler creates a container credentials handler
+func newECSHandler(token string, retrieve func() (*STSCredentials, error)) *ecsHandler {
+	return &ecsHandler{
+		token:    token,
+		retrieve: retrieve,
+	}
+}
+
+// ServeHTTP implements http.Handler
+func (h *ecsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte(h.token)) != 1 {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	creds, err := h.retrieve()
+	if err != nil {
+		http.Error(w, "failed to retrieve credentials", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, ECSCredentials{
+		AccessKeyID:     creds.AccessKeyID,
+		SecretAccessKey: creds.SecretAccessKey,
+		Token:           creds.SessionToken,
+		Expiration:      creds.Expiration.UTC().Format(time.RFC3339),
+		RoleArn:         creds.RoleARN,
+	})
+}
+
+// ecsServer is a loopback container credentials endpoint for one child process
+type ecsServer struct {
+	URL   string
+	Token string
+
+	server *http.Server
+	done   chan struct{}
+}
+
+// startECSServer starts serving a provider's credentials on a random loopback
+// port, refreshing them in the background until Stop is called
+func startECSServer(provider *refreshingProvider) (*ecsServer, error) {
+	tokenBytes := make([]byte, 32)
+	if _, err := rand.Read(tokenBytes); err != nil {
+		return nil, fmt.Errorf("failed to generate authorization token: %w", err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(tokenBytes)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("failed to start credentials server: %w", err)
+	}
+
+	s := &ecsServer{
+		URL:   fmt.Sprintf("http://%s/", listener.Addr()),
+		Token: token,
+		server: &http.Server{
+			Handler:           newECSHandler(token, provider.Retrieve),
+			ReadHeaderTimeout: 10 * time.Second,
+		},
+		done: make(chan struct{}),
+	}
+
+	go s.server.Serve(listener)
+	go provider.RefreshLoop(s.done)
+
+	return s, nil
+}
+
+// Stop shuts the server down and ends background refreshes
+func (s *ecsServer) Stop() {
+	close(s.done)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	s.server.Shutdown(ctx)
+}
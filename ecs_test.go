@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestECSHandler(t *testing.T) {
+	creds := &STSCredentials{
+		AccessKeyID:     "ASIAECSTESTACCESS123",
+		SecretAccessKey: "ecsSecretKey",
+		SessionToken:    "ecsSessionToken",
+		Expiration:      time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC),
+		RoleARN:         "arn:aws:iam::123456789012:role/Test",
+	}
+	handler := newECSHandler("secret-token", func() (*STSCredentials, error) {
+		return creds, nil
+	})
+
+	tests := []struct {
+		name       string
+		method     string
+		auth       string
+		wantStatus int
+	}{
+		{"valid token", "GET", "secret-token", http.StatusOK},
+		{"missing token", "GET", "", http.StatusUnauthorized},
+		{"wrong token", "GET", "secret-token2", http.StatusUnauthorized},
+		{"wrong method", "POST", "secret-token", http.StatusMethodNotAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/", nil)
+			if tt.auth != "" {
+				req.Header.Set("Authorization", tt.auth)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			var doc ECSCredentials
+			if err := json.Unmarshal(rec.Body.Bytes(), &doc); err != nil {
+				t.Fatalf("response should be JSON: %v", err)
+			}
+			if doc.AccessKeyID != creds.AccessKeyID || doc.Token != creds.SessionToken {
+				t.Errorf("unexpected credentials: %+v", doc)
+			}
+			if doc.Expiration != "2030-01-02T03:04:05Z" {
+				t.Errorf("expiration: got %q", doc.Expiration)
+			}
+			if doc.RoleArn != creds.RoleARN {
+				t.Errorf("role ARN: got %q", doc.RoleArn)
+			}
+		})
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	imdsTokenPath       = "/latest/api/token"
+	imdsCredentialsPath = "/latest/meta-data/iam/security-credentials/"
+	imdsRegionPath      = "/latest/meta-data/placement/region"
+	imdsIdentityPath    = "/latest/dynamic/instance-identity/document"
+
+	imdsTokenTTLHeader = "X-aws-ec2-metadata-token-ttl-seconds"
+	imdsTokenHeader    = "X-aws-ec2-metadata-token"
+
+	// IMDSv2 session tokens may live between 1 second and 6 hours
+	imdsMaxTokenTTL = 21600
+)
+
+// IMDSCredentials is the document served by the EC2 instance metadata
+// service for an instance profile role
+type IMDSCredentials struct {
+	Code            string `json:"Code"`
+	LastUpdated     string `json:"LastUpdated"`
+	Type            string `json:"Type"`
+	AccessKeyID     string `json:"AccessKeyId"`
+	SecretAccessKey string `json:"SecretAccessKey"`
+	Token           string `json:"Token"`
+	Expiration      string `json:"Expiration"`
+}
+
+// imdsHandler emulates the credential paths of the EC2 instance metadata
+// service (IMDSv2 only: every GET needs a token from the PUT handshake)
+type imdsHandler struct {
+	profile  string
+	retrieve func() (*STSCredentials, error)
+
+	mu     sync.Mutex
+	tokens map[string]time.Time // token -> expiry
+}
+
+// newIMDSHandler creates an IMDS handler serving credentials for a profile
+func newIMDSHandler(profile string, retrieve func() (*STSCredentials, error)) *imdsHandler {
+	return &imdsHandler{
+		profile:  profile,
+		retrieve: retrieve,
+		tokens:   make(map[string]time.Time),
+	}
+}
+
+// ServeHTTP implements http.Handler
+func (h *imdsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == imdsTokenPath {
+		h.serveToken(w, r)
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !h.validToken(r.Header.Get(imdsTokenHeader)) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.URL.Path {
+	case imdsCredentialsPath:
+		fmt.Fprint(w, h.profile)
+	case imdsCredentialsPath + h.profile:
+		h.serveCredentials(w)
+	case imdsRegionPath:
+		h.serveRegion(w)
+	case imdsIdentityPath:
+		h.serveIdentityDocument(w)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// serveToken implements the IMDSv2 PUT token handshake
+func (h *imdsHandler) serveToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Like the real service, refuse requests that went through a proxy
+	if r.Header.Get("X-Forwarded-For") != "" {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	ttl, err := strconv.Atoi(r.Header.Get(imdsTokenTTLHeader))
+	if err != nil || ttl < 1 || ttl > imdsMaxTokenTTL {
+		http.Error(w, "invalid token TTL", http.StatusBadRequest)
+		return
+	}
+
+	tokenBytes := make([]byte, 32)
+	if _, err := rand.Read(tokenBytes); err != nil {
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	token := base64.RawURLEncoding.EncodeToString(tokenBytes)
+
+	h.mu.Lock()
+	now := time.Now()
+	for t, expiry := range h.tokens {
+		if now.After(expiry) {
+			delete(h.tokens, t)
+		}
+	}
+	h.tokens[token] = now.Add(time.Duration(ttl) * time.Second)
+	h.mu.Unlock()
+
+	w.Header().Set(imdsTokenTTLHeader, strconv.Itoa(ttl))
+	fmt.Fprint(w, token)
+}
+
+// validToken checks an IMDSv2 session token
+func (h *imdsHandler) validToken(token string) bool {
+	if token == "" {
+		return false
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	expiry, ok := h.tokens[token]
+	return ok && time.Now().Before(expiry)
+}
+
+// serveCredentials serves the instance profile credentials document
+func (h *imdsHandler) serveCredentials(w http.ResponseWriter) {
+	creds, err := h.retrieve()
+	if err != nil {
+		http.Error(w, "failed to retrieve credentials", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, IMDSCredentials{
+		Code:            "Success",
+		LastUpdated:     time.Now().UTC().Format(time.RFC3339),
+		Type:            "AWS-HMAC",
+		AccessKeyID:     creds.AccessKeyID,
+		SecretAccessKey: creds.SecretAccessKey,
+		Token:           creds.SessionToken,
+		Expiration:      creds.Expiration.UTC().Format(time.RFC3339),
+	})
+}
+
+// serveRegion serves the region of the profile's credentials
+func (h *imdsHandler) serveRegion(w http.ResponseWriter) {
+	creds, err := h.retrieve()
+	if err != nil {
+		http.Error(w, "failed to retrieve credentials", http.StatusInternalServerError)
+		return
+	}
+
+	fmt.Fprint(w, creds.Region)
+}
+
+// serveIdentityDocument serves the subset of the instance identity document SDKs use
+func (h *imdsHandler) serveIdentityDocument(w http.ResponseWriter) {
+	creds, err := h.retrieve()
+	if err != nil {
+		http.Error(w, "failed to retrieve credentials", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, map[string]string{"region": creds.Region})
+}
+
+// resolveLoopbackAddr resolves the listen address of the IMDS server and
+// makes sure it is a loopback address. The endpoint hands credentials to
+// anyone who can reach it, so it must never listen on the network. The
+// resolved address is returned, so a name cannot resolve differently later
+func resolveLoopbackAddr(addr string) (string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", fmt.Errorf("invalid listen address %q: %w", addr, err)
+	}
+	if host == "" {
+		return "", fmt.Errorf("listen address %s is not a loopback address (use e.g. 127.0.0.1%s)", addr, addr)
+	}
+
+	ips, err := net.LookupIP(host)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve listen address %s: %w", addr, err)
+	}
+	for _, ip := range ips {
+		if !ip.IsLoopback() {
+			return "", fmt.Errorf("listen address %s is not a loopback address (%s): the metadata endpoint has no authentication", addr, ip)
+		}
+	}
+
+	return net.JoinHostPort(ips[0].String(), port), nil
+}
+
+// writeJSON writes a JSON response body
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		http.Error(w, "internal error", http.StatusInternalServerError)
+	}
+}
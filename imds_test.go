@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIMDSHandler(t *testing.T) {
+	creds := &STSCredentials{
+		AccessKeyID:     "ASIAIMDSTESTACCESS12",
+		SecretAccessKey: "imdsSecretKey",
+		SessionToken:    "imdsSessionToken",
+		Expiration:      time.Now().Add(time.Hour),
+		Region:          "eu-west-1",
+	}
+	handler := newIMDSHandler("testprofile", func() (*STSCredentials, error) {
+		return creds, nil
+	})
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	do := func(method, path string, headers map[string]string) (int, string) {
+		t.Helper()
+		req, err := http.NewRequest(method, server.URL+path, nil)
+		if err != nil {
+			t.Fatalf("failed to create request: %v", err)
+		}
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	// Requests without a session token are rejected (IMDSv2 only)
+	if status, _ := do("GET", imdsCredentialsPath, nil); status != http.StatusUnauthorized {
+		t.Errorf("GET without token: got status %d, want %d", status, http.StatusUnauthorized)
+	}
+	if status, _ := do("GET", imdsCredentialsPath, map[string]string{imdsTokenHeader: "bogus"}); status != http.StatusUnauthorized {
+		t.Errorf("GET with unknown token: got status %d, want %d", status, http.StatusUnauthorized)
+	}
+
+	// Token requests need a valid TTL and must not be proxied
+	if status, _ := do("PUT", imdsTokenPath, nil); status != http.StatusBadRequest {
+		t.Errorf("PUT without TTL: got status %d, want %d", status, http.StatusBadRequest)
+	}
+	if status, _ := do("PUT", imdsTokenPath, map[string]string{imdsTokenTTLHeader: "99999"}); status != http.StatusBadRequest {
+		t.Errorf("PUT with excessive TTL: got status %d, want %d", status, http.StatusBadRequest)
+	}
+	if status, _ := do("PUT", imdsTokenPath, map[string]string{imdsTokenTTLHeader: "60", "X-Forwarded-For": "10.0.0.1"}); status != http.StatusForbidden {
+		t.Errorf("proxied PUT: got status %d, want %d", status, http.StatusForbidden)
+	}
+
+	status, token := do("PUT", imdsTokenPath, map[string]string{imdsTokenTTLHeader: "60"})
+	if status != http.StatusOK || token == "" {
+		t.Fatalf("PUT token: got status %d, token %q", status, token)
+	}
+	auth := map[string]string{imdsTokenHeader: token}
+
+	status, body := do("GET", imdsCredentialsPath, auth)
+	if status != http.StatusOK || body != "testprofile" {
+		t.Errorf("role listing: got status %d, body %q", status, body)
+	}
+
+	status, body = do("GET", imdsCredentialsPath+"testprofile", auth)
+	if status != http.StatusOK {
+		t.Fatalf("credentials: got status %d", status)
+	}
+	var doc IMDSCredentials
+	if err := json.Unmarshal([]byte(body), &doc); err != nil {
+		t.Fatalf("credentials should be JSON: %v", err)
+	}
+	if doc.Code != "Success" || doc.AccessKeyID != creds.AccessKeyID || doc.Token != creds.SessionToken {
+		t.Errorf("unexpected credentials document: %+v", doc)
+	}
+
+	status, body = do("GET", imdsRegionPath, auth)
+	if status != http.StatusOK || body != "eu-west-1" {
+		t.Errorf("region: got status %d, body %q", status, body)
+	}
+
+	if status, _ := do("GET", imdsCredentialsPath+"otherprofile", auth); status != http.StatusNotFound {
+		t.Errorf("unknown role: got status %d, want %d", status, http.StatusNotFound)
+	}
+}
+
+func TestIMDSHandlerRetrieveError(t *testing.T) {
+	handler := newIMDSHandler("testprofile", func() (*STSCredentials, error) {
+		return nil, errors.New("vault locked")
+	})
+
+	req := httptest.NewRequest("PUT", imdsTokenPath, nil)
+	req.Header.Set(imdsTokenTTLHeader, "60")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	token := rec.Body.String()
+
+	req = httptest.NewRequest("GET", imdsCredentialsPath+"testprofile", nil)
+	req.Header.Set(imdsTokenHeader, token)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("got status %d, want %d", rec.Code, http.StatusInternalServerError)
+	}
+	if strings.Contains(rec.Body.String(), "vault locked") {
+		t.Error("internal errors should not be exposed to clients")
+	}
+}
+
+func TestResolveLoopbackAddr(t *testing.T) {
+	tests := []struct {
+		addr    string
+		want    string
+		wantErr bool
+	}{
+		{addr: "127.0.0.1:9099", want: "127.0.0.1:9099"},
+		{addr: "[::1]:9099", want: "[::1]:9099"},
+		{addr: "127.0.0.2:0", want: "127.0.0.2:0"},
+		{addr: "0.0.0.0:9099", wantErr: true},
+		{addr: "[::]:9099", wantErr: true},
+		{addr: ":9099", wantErr: true},
+		{addr: "192.0.2.10:9099", wantErr: true},
+		{addr: "9099", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.addr, func(t *testing.T) {
+			got, err := resolveLoopbackAddr(tt.addr)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got %s", got)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("resolveLoopbackAddr(%q) = %q, %v; want %q", tt.addr, got, err, tt.want)
+			}
+		})
+	}
+}
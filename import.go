@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// importEntry is a set of long-term keys found in a plaintext source
+type importEntry struct {
+	profile      string
+	accessKey    string
+	secretKey    string
+	sessionToken bool // temporary credentials, which are not imported
+}
+
+// getSharedCredentialsPath returns the path to the shared credentials file
+func getSharedCredentialsPath() (string, error) {
+	if path := os.Getenv("AWS_SHARED_CREDENTIALS_FILE"); path != "" {
+		return path, nil
+	}
+
+	// Check for test mode
+	if testDir := os.Getenv("CAWS_TEST_DIR"); testDir != "" {
+		return filepath.Join(testDir, "credentials"), nil
+	}
+
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(homeDir, ".aws", "credentials"), nil
+}
+
+// parseCredentialsFile reads the profiles of a shared credentials file in file order
+func parseCredentialsFile(r io.Reader) ([]importEntry, error) {
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	file := parseINI(string(content))
+	var entries []importEntry
+	for _, profile := range file.sections() {
+		values := file.values(profile)
+		_, hasToken := values["aws_session_token"]
+		_, hasLegacyToken := values["aws_security_token"]
+		entries = append(entries, importEntry{
+			profile:      profile,
+			accessKey:    values["aws_access_key_id"],
+			secretKey:    values["aws_secret_access_key"],
+			sessionToken: hasToken || hasLegacyToken,
+		})
+	}
+
+	return entries, nil
+}
+
+// parseAccessKeysCSV reads the single key of an IAM console accessKeys.csv download
+func parseAccessKeysCSV(r io.Reader) (accessKey, secretKey string, err error) {
+	records, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return "", "", fmt.Errorf("failed to parse CSV: %w", err)
+	}
+	if len(records) < 2 {
+		return "", "", fmt.Errorf("CSV has no access key rows")
+	}
+	if len(records) > 2 {
+		return "", "", fmt.Errorf("CSV contains %d access keys, expected one", len(records)-1)
+	}
+
+	// Find columns by header, which may start with a UTF-8 byte order mark
+	accessCol, secretCol := -1, -1
+	for i, name := range records[0] {
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		switch name {
+		case "access key id":
+			accessCol = i
+		case "secret access key":
+			secretCol = i
+		}
+	}
+	if accessCol < 0 || secretCol < 0 {
+		return "", "", fmt.Errorf("CSV is missing the 'Access key ID' and 'Secret access key' columns")
+	}
+
+	row := records[1]
+	if accessCol >= len(row) || secretCol >= len(row) {
+		return "", "", fmt.Errorf("CSV row is missing columns")
+	}
+
+	return strings.TrimSpace(row[accessCol]), strings.TrimSpace(row[secretCol]), nil
+}
+
+// removeCredentialsEntries removes the keys of the given profiles from
+// shared credentials file content. Sections left without settings are
+// removed entirely; everything else is kept as is
+func removeCredentialsEntries(content string, profiles map[string]bool) string {
+	file := parseINI(content)
+	for _, profile := range file.sections() {
+		if !profiles[profile] {
+			continue
+		}
+		file.unset(profile, "aws_access_key_id")
+		file.unset(profile, "aws_secret_access_key")
+		if len(file.keys(profile)) == 0 {
+			file.removeSection(profile)
+		}
+	}
+	return file.String()
+}
+
+// removePlaintextCredentials rewrites the shared credentials file without
+// the keys of the given profiles, replacing it atomically
+func removePlaintextCredentials(path string, profiles map[string]bool) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	updated := removeCredentialsEntries(string(content), profiles)
+
+	tempPath := path + ".tmp"
+	if err := os.WriteFile(tempPath, []byte(updated), info.Mode().Perm()); err != nil {
+		return fmt.Errorf("failed to write %s: %w", tempPath, err)
+	}
+	if err := os.Rename(tempPath, path); err != nil {
+		os.Remove(tempPath)
+		return fmt.Errorf("failed to replace %s: %w", path, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,414 @@
+package main
+
+import (
+	"strings"
+)
+
+// iniLineKind classifies the lines of an INI document
+type iniLineKind int
+
+const (
+	iniOther        iniLineKind = iota // blank line, comment or unparseable text
+	iniSection                         // [section name]
+	iniKey                             // key = value
+	iniSubKey                          // indented key = value under a key with an empty value
+	iniContinuation                    // indented text continuing the previous value
+)
+
+// iniLine is one line of an INI document. The raw text is kept so that
+// unchanged lines are written back byte for byte
+type iniLine struct {
+	text    string // raw text including the line ending
+	kind    iniLineKind
+	section string // normalized name of the section the line belongs to
+	key     string // "parent.child" for sub-keys
+	value   string // value without inline comment; continuation lines are joined with "\n"
+
+	// Byte range of the value in text, used to replace it in place
+	valueStart, valueEnd int
+}
+
+// iniFile is a round-trip INI document in the format of ~/.aws/config.
+// It understands inline comments, indented sub-properties (such as "s3 ="
+// blocks), continuation lines and duplicate sections, and edits keys and
+// sections while keeping comments, ordering and whitespace intact
+type iniFile struct {
+	lines   []iniLine
+	newline string // line ending used for inserted lines
+}
+
+// parseINI parses INI content; it never fails, lines it does not understand are kept as is
+func parseINI(content string) *iniFile {
+	f := &iniFile{newline: "\n"}
+	if strings.Contains(content, "\r\n") {
+		f.newline = "\r\n"
+	}
+
+	section := ""
+	parent := -1 // index of the key line that indented lines belong to
+
+	for _, text := range strings.SplitAfter(content, "\n") {
+		if text == "" {
+			continue
+		}
+		line := iniLine{text: text, kind: iniOther, section: section}
+		body := strings.TrimRight(text, "\r\n")
+		trimmed := strings.TrimSpace(body)
+		indented := trimmed != "" && (body[0] == ' ' || body[0] == '\t')
+
+		switch {
+		case trimmed == "" || trimmed[0] == '#' || trimmed[0] == ';':
+			// Comments do not end a sub-property block, blank lines do
+			if trimmed == "" {
+				parent = -1
+			}
+
+		case !indented && trimmed[0] == '[':
+			end := strings.Index(trimmed, "]")
+			if end < 0 {
+				break
+			}
+			section = normalizeSectionName(trimmed[1:end])
+			line.kind = iniSection
+			line.section = section
+			parent = -1
+
+		case indented && parent >= 0:
+			owner := &f.lines[parent]
+			key, start, end, ok := parseINIKeyValue(text)
+			opensBlock := owner.kind == iniKey && owner.value == ""
+			if ok && (opensBlock || owner.kind == iniSubKey) {
+				parentKey, _, _ := strings.Cut(owner.key, ".")
+				line.kind = iniSubKey
+				line.key = parentKey + "." + key
+				line.value = text[start:end]
+				line.valueStart, line.valueEnd = start, end
+				parent = len(f.lines)
+				break
+			}
+			line.kind = iniContinuation
+			owner.value += "\n" + stripINIComment(trimmed)
+
+		default:
+			key, start, end, ok := parseINIKeyValue(text)
+			if !ok || section == "" {
+				break
+			}
+			line.kind = iniKey
+			line.key = key
+			line.value = text[start:end]
+			line.valueStart, line.valueEnd = start, end
+			parent = len(f.lines)
+		}
+
+		f.lines = append(f.lines, line)
+	}
+
+	return f
+}
+
+// parseINIKeyValue splits a "key = value" line, returning the key and the
+// byte range of the value without surrounding space or inline comment
+func parseINIKeyValue(text string) (key string, start, end int, ok bool) {
+	eq := strings.Index(text, "=")
+	if eq < 0 {
+		return "", 0, 0, false
+	}
+	key = strings.TrimSpace(text[:eq])
+	if key == "" {
+		return "", 0, 0, false
+	}
+
+	rest := text[eq+1:]
+	value := strings.TrimLeft(rest, " \t")
+	start = eq + 1 + len(rest) - len(value)
+	value = strings.TrimRight(stripINIComment(value), " \t\r\n")
+	return key, start, start + len(value), true
+}
+
+// stripINIComment removes an inline comment, which must be preceded by whitespace
+// so that values such as URLs with fragments are kept intact
+func stripINIComment(value string) string {
+	for i := 1; i < len(value); i++ {
+		if (value[i] == '#' || value[i] == ';') && (value[i-1] == ' ' || value[i-1] == '\t') {
+			return strings.TrimRight(value[:i], " \t")
+		}
+	}
+	return value
+}
+
+// normalizeSectionName collapses whitespace in a section header, so that
+// "[profile  dev ]" and "[profile dev]" name the same section
+func normalizeSectionName(name string) string {
+	return strings.Join(strings.Fields(name), " ")
+}
+
+// String returns the document content
+func (f *iniFile) String() string {
+	var b strings.Builder
+	for _, line := range f.lines {
+		b.WriteString(line.text)
+	}
+	return b.String()
+}
+
+// sections returns the names of all sections in file order, without duplicates
+func (f *iniFile) sections() []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, line := range f.lines {
+		if line.kind == iniSection && !seen[line.section] {
+			seen[line.section] = true
+			names = append(names, line.section)
+		}
+	}
+	return names
+}
+
+// hasSection reports whether a section exists
+func (f *iniFile) hasSection(section string) bool {
+	for _, line := range f.lines {
+		if line.kind == iniSection && line.section == section {
+			return true
+		}
+	}
+	return false
+}
+
+// values returns every key of a section, with sub-keys as "parent.child".
+// Duplicate sections are merged and later values win
+func (f *iniFile) values(section string) map[string]string {
+	values := make(map[string]string)
+	for _, line := range f.lines {
+		if line.section == section && (line.kind == iniKey || line.kind == iniSubKey) {
+			values[line.key] = line.value
+		}
+	}
+	return values
+}
+
+// keys returns the keys of a section in file order, without duplicates
+func (f *iniFile) keys(section string) []string {
+	var keys []string
+	seen := make(map[string]bool)
+	for _, line := range f.lines {
+		if line.section == section && (line.kind == iniKey || line.kind == iniSubKey) && !seen[line.key] {
+			seen[line.key] = true
+			keys = append(keys, line.key)
+		}
+	}
+	return keys
+}
+
+// get returns the value of a key in a section
+func (f *iniFile) get(section, key string) (string, bool) {
+	value, ok := f.values(section)[key]
+	return value, ok
+}
+
+// set sets a key in a section, replacing the value in place if the key
+// exists and adding the section if needed. Sub-keys are given as "parent.child"
+func (f *iniFile) set(section, key, value string) {
+	if i := f.lastKey(section, key); i >= 0 {
+		line := &f.lines[i]
+		line.text = line.text[:line.valueStart] + value + line.text[line.valueEnd:]
+		line.value = value
+		line.valueEnd = line.valueStart + len(value)
+		// The new value replaces any continuation lines or sub-keys of the old one
+		f.removeLines(i+1, f.blockEnd(i))
+		return
+	}
+
+	parentKey, childKey, isSubKey := strings.Cut(key, ".")
+	if !isSubKey {
+		f.insertLines(f.sectionEnd(section), f.newKeyLine(section, key, "", key, value))
+		return
+	}
+
+	parent := f.lastKey(section, parentKey)
+	if parent < 0 {
+		f.insertLines(f.sectionEnd(section),
+			f.newKeyLine(section, parentKey, "", parentKey, ""),
+			f.newKeyLine(section, key, "  ", childKey, value))
+		return
+	}
+
+	// A parent with a value of its own would turn the sub-key into a continuation line
+	if f.lines[parent].value != "" {
+		f.set(section, parentKey, "")
+	}
+
+	// Match the indentation of existing sub-keys
+	at := f.blockEnd(parent)
+	indent := "  "
+	if last := f.lines[at-1]; last.kind == iniSubKey {
+		indent = last.text[:len(last.text)-len(strings.TrimLeft(last.text, " \t"))]
+	}
+	f.insertLines(at, f.newKeyLine(section, key, indent, childKey, value))
+}
+
+// unset removes a key from all occurrences of a section, reporting whether
+// it existed. Removing a parent key removes its sub-keys, and removing the
+// last sub-key removes the parent
+func (f *iniFile) unset(section, key string) bool {
+	removed := false
+	for i := len(f.lines) - 1; i >= 0; i-- {
+		line := f.lines[i]
+		if line.section != section || line.key != key || (line.kind != iniKey && line.kind != iniSubKey) {
+			continue
+		}
+		f.removeLines(i, f.blockEnd(i))
+		removed = true
+	}
+
+	if parentKey, _, isSubKey := strings.Cut(key, "."); removed && isSubKey {
+		prefix := parentKey + "."
+		for k := range f.values(section) {
+			if strings.HasPrefix(k, prefix) {
+				return true
+			}
+		}
+		f.unset(section, parentKey)
+	}
+
+	return removed
+}
+
+// addSection appends an empty section unless it already exists
+func (f *iniFile) addSection(section string) {
+	// sectionEnd appends missing sections
+	f.sectionEnd(section)
+}
+
+// removeSection removes all occurrences of a section, reporting whether it
+// existed. Comments trailing a section are kept, they usually describe the next one
+func (f *iniFile) removeSection(section string) bool {
+	removed := false
+	for i := len(f.lines) - 1; i >= 0; i-- {
+		if f.lines[i].kind != iniSection || f.lines[i].section != section {
+			continue
+		}
+
+		// Remove through the last setting, then the blank lines that separated it
+		end := i + 1
+		for j := i + 1; j < len(f.lines) && f.lines[j].kind != iniSection; j++ {
+			if f.lines[j].kind != iniOther {
+				end = j + 1
+			}
+		}
+		for end < len(f.lines) && f.lines[end].kind == iniOther && strings.TrimSpace(f.lines[end].text) == "" {
+			end++
+		}
+		f.removeLines(i, end)
+		removed = true
+	}
+	return removed
+}
+
+// lastKey returns the index of the last line setting a key in a section, or -1
+func (f *iniFile) lastKey(section, key string) int {
+	for i := len(f.lines) - 1; i >= 0; i-- {
+		line := f.lines[i]
+		if line.section == section && line.key == key && (line.kind == iniKey || line.kind == iniSubKey) {
+			return i
+		}
+	}
+	return -1
+}
+
+// blockEnd returns the index after a key line and the indented lines
+// (continuations, sub-keys and comments between them) belonging to it
+func (f *iniFile) blockEnd(i int) int {
+	end := i + 1
+	for j := i + 1; j < len(f.lines); j++ {
+		line := f.lines[j]
+		switch {
+		case line.kind == iniContinuation, line.kind == iniSubKey && f.lines[i].kind == iniKey:
+			end = j + 1
+		case line.kind == iniOther && strings.TrimSpace(line.text) != "" && strings.TrimLeft(line.text, " \t") != line.text:
+			// Indented comment, part of the block if more of it follows
+		default:
+			return end
+		}
+	}
+	return end
+}
+
+// sectionEnd returns the index after the last setting of the last occurrence
+// of a section, appending the section if it does not exist
+func (f *iniFile) sectionEnd(section string) int {
+	header := -1
+	for i := len(f.lines) - 1; i >= 0; i-- {
+		if f.lines[i].kind == iniSection && f.lines[i].section == section {
+			header = i
+			break
+		}
+	}
+
+	if header < 0 {
+		var added []iniLine
+		if len(f.lines) > 0 {
+			last := &f.lines[len(f.lines)-1]
+			if !strings.HasSuffix(last.text, "\n") {
+				last.text += f.newline
+			}
+			if strings.TrimSpace(last.text) != "" {
+				added = append(added, f.newLine("", f.lines[len(f.lines)-1].section))
+			}
+		}
+		headerLine := f.newLine("["+section+"]", section)
+		headerLine.kind = iniSection
+		f.lines = append(f.lines, append(added, headerLine)...)
+		return len(f.lines)
+	}
+
+	end := header + 1
+	for i := header + 1; i < len(f.lines) && f.lines[i].kind != iniSection; i++ {
+		if f.lines[i].kind != iniOther {
+			end = i + 1
+		}
+	}
+	return end
+}
+
+// newLine creates a non-key line to insert
+func (f *iniFile) newLine(text, section string) iniLine {
+	return iniLine{text: text + f.newline, kind: iniOther, section: section}
+}
+
+// newKeyLine creates a key line to insert; key is the full name of the key
+// and name is how it is written, which differs for sub-keys
+func (f *iniFile) newKeyLine(section, key, indent, name, value string) iniLine {
+	text := indent + name + " ="
+	if value != "" {
+		text += " " + value
+	}
+
+	kind := iniKey
+	if strings.Contains(key, ".") {
+		kind = iniSubKey
+	}
+
+	return iniLine{
+		text:       text + f.newline,
+		kind:       kind,
+		section:    section,
+		key:        key,
+		value:      value,
+		valueStart: len(text) - len(value),
+		valueEnd:   len(text),
+	}
+}
+
+// insertLines inserts lines before index at, terminating the preceding line if needed
+func (f *iniFile) insertLines(at int, lines ...iniLine) {
+	if at > 0 && !strings.HasSuffix(f.lines[at-1].text, "\n") {
+		f.lines[at-1].text += f.newline
+	}
+	f.lines = append(f.lines[:at], append(lines, f.lines[at:]...)...)
+}
+
+// removeLines removes the lines in [from, to)
+func (f *iniFile) removeLines(from, to int) {
+	f.lines = append(f.lines[:from], f.lines[to:]...)
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"testing"
+)
+
+const testINI = `# Managed by hand
+[default]
+region = us-west-2 # inline comment
+
+[profile  dev ]
+sso_start_url = https://corp.awsapps.com/start#/
+s3 =
+  max_concurrent_requests = 20
+  ; tuned for the VPN
+  multipart_threshold = 64MB
+region = eu-west-1
+
+[sso-session corp]
+sso_region = us-east-1
+
+[profile dev]
+output = json
+region = eu-central-1
+`
+
+func TestParseINIRoundTrip(t *testing.T) {
+	for _, content := range []string{testINI, "", "[default]\r\nregion = us-east-1\r\n", "[default]\nregion = us-east-1"} {
+		if got := parseINI(content).String(); got != content {
+			t.Errorf("round trip changed content:\n%q\nwant:\n%q", got, content)
+		}
+	}
+}
+
+func TestParseINIValues(t *testing.T) {
+	config := parseINI(testINI)
+
+	if got := config.sections(); len(got) != 3 || got[0] != "default" || got[1] != "profile dev" || got[2] != "sso-session corp" {
+		t.Errorf("sections: got %q", got)
+	}
+
+	tests := []struct {
+		section, key, want string
+	}{
+		{"default", "region", "us-west-2"},
+		{"profile dev", "sso_start_url", "https://corp.awsapps.com/start#/"},
+		{"profile dev", "s3.max_concurrent_requests", "20"},
+		{"profile dev", "s3.multipart_threshold", "64MB"},
+		{"profile dev", "output", "json"},
+		// Duplicate sections are merged, later values win
+		{"profile dev", "region", "eu-central-1"},
+		{"sso-session corp", "sso_region", "us-east-1"},
+	}
+	for _, tt := range tests {
+		got, ok := config.get(tt.section, tt.key)
+		if !ok || got != tt.want {
+			t.Errorf("get(%q, %q) = %q, %v; want %q", tt.section, tt.key, got, ok, tt.want)
+		}
+	}
+
+	if _, ok := config.get("profile dev", "max_concurrent_requests"); ok {
+		t.Error("sub-keys should only be visible under their parent")
+	}
+}
+
+func TestINISet(t *testing.T) {
+	config := parseINI(testINI)
+
+	config.set("default", "region", "ap-southeast-2")
+	config.set("profile dev", "region", "us-east-2")
+	config.set("profile dev", "s3.max_concurrent_requests", "10")
+	config.set("profile dev", "s3.addressing_style", "path")
+	config.set("profile dev", "mfa_serial", "arn:aws:iam::123456789012:mfa/alice")
+	config.set("profile new", "region", "sa-east-1")
+	config.set("profile new", "s3.use_accelerate_endpoint", "true")
+
+	want := `# Managed by hand
+[default]
+region = ap-southeast-2 # inline comment
+
+[profile  dev ]
+sso_start_url = https://corp.awsapps.com/start#/
+s3 =
+  max_concurrent_requests = 10
+  ; tuned for the VPN
+  multipart_threshold = 64MB
+  addressing_style = path
+region = eu-west-1
+
+[sso-session corp]
+sso_region = us-east-1
+
+[profile dev]
+output = json
+region = us-east-2
+mfa_serial = arn:aws:iam::123456789012:mfa/alice
+
+[profile new]
+region = sa-east-1
+s3 =
+  use_accelerate_endpoint = true
+`
+	if got := config.String(); got != want {
+		t.Errorf("set result:\n%s\nwant:\n%s", got, want)
+	}
+
+	// The edited document parses back to the same values
+	reparsed := parseINI(config.String())
+	if got, _ := reparsed.get("profile new", "s3.use_accelerate_endpoint"); got != "true" {
+		t.Errorf("reparsed sub-key: got %q", got)
+	}
+	if got, _ := reparsed.get("profile dev", "s3.addressing_style"); got != "path" {
+		t.Errorf("reparsed sub-key: got %q", got)
+	}
+}
+
+func TestINIUnset(t *testing.T) {
+	config := parseINI(testINI)
+
+	if !config.unset("profile dev", "region") {
+		t.Error("unset region should report removal")
+	}
+	if config.unset("profile dev", "nonexistent") {
+		t.Error("unset of a missing key should report false")
+	}
+	config.unset("profile dev", "s3.max_concurrent_requests")
+	config.unset("profile dev", "s3.multipart_threshold")
+
+	want := `# Managed by hand
+[default]
+region = us-west-2 # inline comment
+
+[profile  dev ]
+sso_start_url = https://corp.awsapps.com/start#/
+  ; tuned for the VPN
+
+[sso-session corp]
+sso_region = us-east-1
+
+[profile dev]
+output = json
+`
+	if got := config.String(); got != want {
+		t.Errorf("unset result:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestINIRemoveSection(t *testing.T) {
+	content := `[profile a]
+region = us-east-1
+
+# Describes b
+[profile b]
+region = eu-west-1
+# trailing comment
+
+[profile a]
+output = json
+`
+	config := parseINI(content)
+	if !config.removeSection("profile a") {
+		t.Fatal("removeSection should report removal")
+	}
+	if config.removeSection("profile missing") {
+		t.Error("removeSection of a missing section should report false")
+	}
+
+	want := `# Describes b
+[profile b]
+region = eu-west-1
+# trailing comment
+
+`
+	if got := config.String(); got != want {
+		t.Errorf("removeSection result:\n%q\nwant:\n%q", got, want)
+	}
+}
+
+func TestINIAddSection(t *testing.T) {
+	config := parseINI("[default]\nregion = us-east-1")
+	config.addSection("profile dev")
+	config.addSection("default")
+
+	want := "[default]\nregion = us-east-1\n\n[profile dev]\n"
+	if got := config.String(); got != want {
+		t.Errorf("addSection result: %q, want %q", got, want)
+	}
+
+	empty := parseINI("")
+	empty.set("profile dev", "region", "us-east-1")
+	if got := empty.String(); got != "[profile dev]\nregion = us-east-1\n" {
+		t.Errorf("set on empty document: %q", got)
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// vaultLockTimeout is how long to wait for a vault lock held by another
+// process before giving up (--lock-timeout). Zero fails right away
+var vaultLockTimeout time.Duration
+
+// lockRetryInterval is how often a held vault lock is tried again
+const lockRetryInterval = 100 * time.Millisecond
+
+// lockMode selects a shared vault lock for reading or an exclusive one for
+// writing
+type lockMode int
+
+const (
+	lockShared lockMode = iota
+	lockExclusive
+)
+
+// lockMarker follows the PID in lock files of flock-based holders. Older
+// caws versions created the file with O_EXCL and wrote only the PID
+const lockMarker = "flock"
+
+// acquireVaultLock takes a flock(2) lock on <vault>.lock. Any number of
+// processes can hold it shared, or one exclusively. The kernel releases it
+// when the holder exits, so a killed or crashed caws never leaves the vault
+// locked. While another process holds a conflicting lock, it waits up to
+// vaultLockTimeout
+func acquireVaultLock(vaultPath string, mode lockMode) (*os.File, error) {
+	lockPath := vaultPath + ".lock"
+	deadline := time.Now().Add(vaultLockTimeout)
+	waiting := false
+
+	for {
+		lockFile, holder, err := tryVaultLock(lockPath, mode)
+		if err != nil {
+			return nil, err
+		}
+		if lockFile != nil {
+			return lockFile, nil
+		}
+
+		if time.Now().After(deadline) {
+			if vaultLockTimeout > 0 {
+				return nil, fmt.Errorf("timed out after %s waiting for the vault lock held by %s (lock file: %s)", vaultLockTimeout, holder, lockPath)
+			}
+			return nil, fmt.Errorf("vault is locked by another process (%s, lock file: %s)\nUse --lock-timeout to wait for it", holder, lockPath)
+		}
+
+		if !waiting {
+			fmt.Fprintf(os.Stderr, "Waiting for the vault lock held by %s...\n", holder)
+			waiting = true
+		}
+		time.Sleep(lockRetryInterval)
+	}
+}
+
+// tryVaultLock makes one attempt at the vault lock. It returns the locked
+// file, or a description of the process holding the lock
+func tryVaultLock(lockPath string, mode lockMode) (*os.File, string, error) {
+	how := unix.LOCK_SH
+	if mode == lockExclusive {
+		how = unix.LOCK_EX
+	}
+
+	for {
+		lockFile, err := os.OpenFile(lockPath, os.O_CREATE|os.O_RDWR, 0600)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to open lock file: %w", err)
+		}
+
+		if err := unix.Flock(int(lockFile.Fd()), how|unix.LOCK_NB); err != nil {
+			pid, _ := readLockFile(lockFile)
+			lockFile.Close()
+			if errors.Is(err, unix.EWOULDBLOCK) {
+				return nil, describeLockHolder(pid), nil
+			}
+			return nil, "", fmt.Errorf("failed to lock vault: %w", err)
+		}
+
+		// A holder removes the file on release; the lock we got may be on the
+		// unlinked file, which nobody else will see
+		if !isLockPath(lockFile, lockPath) {
+			lockFile.Close()
+			continue
+		}
+
+		pid, flocked := readLockFile(lockFile)
+		if pid != 0 && !flocked && pid != os.Getpid() {
+			// Lock files of older versions are not flocked; only their PID
+			// tells whether they are still held
+			if processAlive(pid) {
+				lockFile.Close()
+				return nil, describeLockHolder(pid), nil
+			}
+			fmt.Fprintf(os.Stderr, "Recovered stale vault lock of process %d\n", pid)
+		}
+
+		// Record the PID for error messages and older versions. Shared
+		// holders overwrite each other, any one of them will do
+		if err := lockFile.Truncate(0); err != nil {
+			lockFile.Close()
+			return nil, "", fmt.Errorf("failed to write lock file: %w", err)
+		}
+		fmt.Fprintf(lockFile, "%d %s\n", os.Getpid(), lockMarker)
+
+		return lockFile, "", nil
+	}
+}
+
+// releaseVaultLock unlocks a lock file from acquireVaultLock. The last
+// holder also removes it
+func releaseVaultLock(lockFile *os.File) {
+	if lockFile == nil {
+		return
+	}
+	// Getting the lock exclusively shows no other process holds it. Remove
+	// while still locked, so waiters never lock a file about to go away
+	if unix.Flock(int(lockFile.Fd()), unix.LOCK_EX|unix.LOCK_NB) == nil {
+		os.Remove(lockFile.Name())
+	}
+	lockFile.Close()
+}
+
+// readLockFile returns the PID in a lock file and whether it was written by
+// a flock-based holder
+func readLockFile(lockFile *os.File) (int, bool) {
+	data, err := io.ReadAll(io.NewSectionReader(lockFile, 0, 64))
+	if err != nil {
+		return 0, false
+	}
+
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return 0, false
+	}
+	pid, err := strconv.Atoi(fields[0])
+	if err != nil || pid <= 0 {
+		return 0, false
+	}
+
+	return pid, len(fields) > 1 && fields[1] == lockMarker
+}
+
+// isLockPath reports whether an open lock file is still the one at lockPath
+func isLockPath(lockFile *os.File, lockPath string) bool {
+	opened, err := lockFile.Stat()
+	if err != nil {
+		return false
+	}
+	current, err := os.Stat(lockPath)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(opened, current)
+}
+
+// processAlive reports whether a process exists, even one of another user
+func processAlive(pid int) bool {
+	err := unix.Kill(pid, 0)
+	return err == nil || errors.Is(err, unix.EPERM)
+}
+
+func describeLockHolder(pid int) string {
+	if pid == 0 {
+		return "an unknown process"
+	}
+	return fmt.Sprintf("process %d", pid)
+}
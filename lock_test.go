@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVaultLock(t *testing.T) {
+	vaultPath := filepath.Join(t.TempDir(), "vault.enc")
+	lockPath := vaultPath + ".lock"
+
+	lockFile, err := acquireVaultLock(vaultPath, lockExclusive)
+	if err != nil {
+		t.Fatalf("acquireVaultLock failed: %v", err)
+	}
+
+	// flock locks belong to the open file, so a second open conflicts even in-process
+	_, err = acquireVaultLock(vaultPath, lockExclusive)
+	if err == nil || !strings.Contains(err.Error(), fmt.Sprintf("locked by another process (process %d", os.Getpid())) {
+		t.Fatalf("expected lock error naming this process, got %v", err)
+	}
+
+	releaseVaultLock(lockFile)
+	if _, err := os.Stat(lockPath); !os.IsNotExist(err) {
+		t.Errorf("lock file should be removed on release: %v", err)
+	}
+
+	// A lock left behind by a killed holder is free again
+	os.WriteFile(lockPath, []byte("12345 flock\n"), 0600)
+	lockFile, err = acquireVaultLock(vaultPath, lockExclusive)
+	if err != nil {
+		t.Fatalf("stale flock lock file should be taken over: %v", err)
+	}
+	releaseVaultLock(lockFile)
+}
+
+func TestVaultLockLegacy(t *testing.T) {
+	vaultPath := filepath.Join(t.TempDir(), "vault.enc")
+	lockPath := vaultPath + ".lock"
+
+	// Lock files of older versions hold a bare PID
+	os.WriteFile(lockPath, []byte(fmt.Sprintf("%d\n", os.Getppid())), 0600)
+	if _, err := acquireVaultLock(vaultPath, lockExclusive); err == nil {
+		t.Fatal("a legacy lock of a running process should be respected")
+	}
+
+	dead := exec.Command("true")
+	if err := dead.Run(); err != nil {
+		t.Fatalf("failed to run true: %v", err)
+	}
+	os.WriteFile(lockPath, []byte(fmt.Sprintf("%d\n", dead.ProcessState.Pid())), 0600)
+	lockFile, err := acquireVaultLock(vaultPath, lockExclusive)
+	if err != nil {
+		t.Fatalf("a legacy lock of a dead process should be recovered: %v", err)
+	}
+	releaseVaultLock(lockFile)
+}
+
+func TestVaultLockTimeout(t *testing.T) {
+	vaultPath := filepath.Join(t.TempDir(), "vault.enc")
+
+	held, err := acquireVaultLock(vaultPath, lockExclusive)
+	if err != nil {
+		t.Fatalf("acquireVaultLock failed: %v", err)
+	}
+
+	vaultLockTimeout = 300 * time.Millisecond
+	defer func() { vaultLockTimeout = 0 }()
+
+	if _, err := acquireVaultLock(vaultPath, lockExclusive); err == nil || !strings.Contains(err.Error(), "timed out after 300ms") {
+		t.Fatalf("expected timeout, got %v", err)
+	}
+
+	// Waiters get the lock once the holder releases it
+	vaultLockTimeout = 5 * time.Second
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		releaseVaultLock(held)
+	}()
+
+	start := time.Now()
+	lockFile, err := acquireVaultLock(vaultPath, lockExclusive)
+	if err != nil {
+		t.Fatalf("waiting for the lock failed: %v", err)
+	}
+	defer releaseVaultLock(lockFile)
+	if waited := time.Since(start); waited < 100*time.Millisecond || waited > 2*time.Second {
+		t.Errorf("unexpected wait of %s", waited)
+	}
+}
+
+func TestVaultLockShared(t *testing.T) {
+	vaultPath := filepath.Join(t.TempDir(), "vault.enc")
+	lockPath := vaultPath + ".lock"
+
+	// Readers share the lock
+	first, err := acquireVaultLock(vaultPath, lockShared)
+	if err != nil {
+		t.Fatalf("first shared lock failed: %v", err)
+	}
+	second, err := acquireVaultLock(vaultPath, lockShared)
+	if err != nil {
+		t.Fatalf("second shared lock failed: %v", err)
+	}
+
+	// Writers wait for all of them
+	if _, err := acquireVaultLock(vaultPath, lockExclusive); err == nil {
+		t.Fatal("exclusive lock should fail while shared locks are held")
+	}
+
+	// Only the last reader removes the lock file
+	releaseVaultLock(first)
+	if _, err := os.Stat(lockPath); err != nil {
+		t.Errorf("lock file should stay while a shared lock is held: %v", err)
+	}
+	if _, err := acquireVaultLock(vaultPath, lockExclusive); err == nil {
+		t.Fatal("exclusive lock should fail while a shared lock is held")
+	}
+	releaseVaultLock(second)
+	if _, err := os.Stat(lockPath); !os.IsNotExist(err) {
+		t.Errorf("lock file should be removed by the last holder: %v", err)
+	}
+
+	// Readers wait for a writer
+	writer, err := acquireVaultLock(vaultPath, lockExclusive)
+	if err != nil {
+		t.Fatalf("exclusive lock failed: %v", err)
+	}
+	if _, err := acquireVaultLock(vaultPath, lockShared); err == nil {
+		t.Fatal("shared lock should fail while an exclusive lock is held")
+	}
+	releaseVaultLock(writer)
+}
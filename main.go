@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"os"
@@ -18,6 +19,7 @@ func main() {
 	flag.BoolVar(versionFlag, "v", false, "show version (shorthand)")
 	helpFlag := flag.Bool("help", false, "show help")
 	flag.BoolVar(helpFlag, "h", false, "show help (shorthand)")
+	flag.DurationVar(&vaultLockTimeout, "lock-timeout", 0, "wait up to `duration` for a vault locked by another caws, e.g. 30s")
 
 	flag.Usage = printUsage
 	flag.Parse()
@@ -33,6 +35,11 @@ func main() {
 		return
 	}
 
+	if vaultLockTimeout < 0 {
+		fmt.Fprintln(os.Stderr, "Error: --lock-timeout cannot be negative")
+		os.Exit(1)
+	}
+
 	// Get subcommand
 	args := flag.Args()
 	if len(args) < 1 {
@@ -47,26 +54,173 @@ func main() {
 	switch command {
 	case "init":
 		err = InitVault()
+	case "upgrade":
+		err = UpgradeVault()
+	case "passwd":
+		err = ChangePassword()
+	case "backup":
+		if len(args) != 2 {
+			fmt.Fprintln(os.Stderr, "Usage: caws backup <file>")
+			os.Exit(1)
+		}
+		err = CreateBackup(args[1])
+	case "restore":
+		fs := flag.NewFlagSet("restore", flag.ExitOnError)
+		replace := fs.Bool("replace", false, "replace all vault profiles instead of merging")
+		positional := parseCommandArgs(fs, args[1:])
+		if len(positional) != 1 {
+			fmt.Fprintln(os.Stderr, "Usage: caws restore <file> [--replace]")
+			os.Exit(1)
+		}
+		err = RestoreBackup(positional[0], *replace)
 	case "add":
 		if len(args) < 2 {
 			fmt.Fprintln(os.Stderr, "Usage: caws add <profile-name>")
 			os.Exit(1)
 		}
 		err = handleAdd(args[1])
+	case "import":
+		fs := flag.NewFlagSet("import", flag.ExitOnError)
+		csvPath := fs.String("csv", "", "import the key from an IAM console access key `file` instead of ~/.aws/credentials")
+		profile := fs.String("profile", "", "only import this profile (profile name for --csv)")
+		removePlaintext := fs.Bool("remove-plaintext", false, "delete imported keys from the plaintext source")
+		if positional := parseCommandArgs(fs, args[1:]); len(positional) != 0 {
+			fmt.Fprintln(os.Stderr, "Usage: caws import [--profile <name>] [--csv <file>] [--remove-plaintext]")
+			os.Exit(1)
+		}
+		err = handleImport(importOptions{csvPath: *csvPath, profile: *profile, removePlaintext: *removePlaintext})
 	case "list", "ls":
 		err = handleList()
 	case "exec":
+		fs := flag.NewFlagSet("exec", flag.ExitOnError)
+		server := fs.Bool("server", false, "serve refreshing credentials to the command from a local endpoint")
+		duration := fs.Duration("duration", 0, "lifetime of the temporary credentials, e.g. 15m or 12h")
+		policy := addPolicyFlags(fs)
+		mfaToken := fs.String("mfa-token", "", "MFA `code` to use instead of prompting")
+		replace := fs.Bool("exec-replace", false, "execute the command in place of caws instead of running it as a child")
+		profile, command := parseExecArgs(fs, args[1:])
+		if profile == "" {
+			fmt.Fprintln(os.Stderr, "Usage: caws exec [--server | --exec-replace] [--duration 1h] [--mfa-token <code>] [--policy <file>] [--policy-arn <arn>]... [--read-only] <profile-name> [-- <command>]")
+			os.Exit(1)
+		}
+		err = handleExec(profile, command, execOptions{
+			server:   *server,
+			duration: *duration,
+			policy:   *policy,
+			mfaToken: *mfaToken,
+			replace:  *replace,
+		})
+	case "export":
+		fs := flag.NewFlagSet("export", flag.ExitOnError)
+		format := fs.String("format", "credential-process", "output format (credential-process)")
+		positional := parseCommandArgs(fs, args[1:])
+		if len(positional) != 1 {
+			fmt.Fprintln(os.Stderr, "Usage: caws export <profile-name> [--format credential-process]")
+			os.Exit(1)
+		}
+		err = handleExport(positional[0], *format)
+	case "serve":
+		fs := flag.NewFlagSet("serve", flag.ExitOnError)
+		imdsProfile := fs.String("imds", "", "serve credentials for `profile` as an EC2 metadata (IMDSv2) endpoint")
+		addr := fs.String("addr", "127.0.0.1:9099", "listen address")
+		positional := parseCommandArgs(fs, args[1:])
+		if *imdsProfile == "" || len(positional) != 0 {
+			fmt.Fprintln(os.Stderr, "Usage: caws serve --imds <profile-name> [--addr 127.0.0.1:9099]")
+			os.Exit(1)
+		}
+		err = handleServe(*imdsProfile, *addr)
+	case "agent":
+		fs := flag.NewFlagSet("agent", flag.ExitOnError)
+		timeout := fs.Duration("timeout", defaultAgentTimeout, "lock the vault again after this long without requests (0 disables)")
+		foreground := fs.Bool("foreground", false, "run in the foreground instead of detaching")
+		stop := fs.Bool("stop", false, "stop the running agent")
+		if positional := parseCommandArgs(fs, args[1:]); len(positional) != 0 {
+			fmt.Fprintln(os.Stderr, "Usage: caws agent [--timeout 15m] [--foreground] | caws agent --stop")
+			os.Exit(1)
+		}
+		err = handleAgent(agentOptions{timeout: *timeout, foreground: *foreground, stop: *stop})
+	case "config":
+		usage := "Usage: caws config show <profile-name> | get <profile-name> <key> | set <profile-name> <key> <value> | unset <profile-name> <key>"
+		if len(args) < 3 {
+			fmt.Fprintln(os.Stderr, usage)
+			os.Exit(1)
+		}
+		switch subcommand := args[1]; {
+		case subcommand == "show" && len(args) == 3:
+			err = handleConfigShow(args[2])
+		case subcommand == "get" && len(args) == 4:
+			err = handleConfigGet(args[2], args[3])
+		case subcommand == "set" && len(args) == 5:
+			err = handleConfigSet(args[2], args[3], args[4])
+		case subcommand == "unset" && len(args) == 4:
+			err = handleConfigUnset(args[2], args[3])
+		default:
+			fmt.Fprintln(os.Stderr, usage)
+			os.Exit(1)
+		}
+	case "cache":
+		usage := "Usage: caws cache list | clear [profile-name] | prune"
 		if len(args) < 2 {
-			fmt.Fprintln(os.Stderr, "Usage: caws exec <profile-name> [-- <command>]")
+			fmt.Fprintln(os.Stderr, usage)
+			os.Exit(1)
+		}
+		switch subcommand := args[1]; {
+		case subcommand == "list" && len(args) == 2:
+			err = handleCacheList()
+		case subcommand == "clear" && len(args) == 2:
+			err = handleCacheClear("")
+		case subcommand == "clear" && len(args) == 3:
+			err = handleCacheClear(args[2])
+		case subcommand == "prune" && len(args) == 2:
+			err = handleCachePrune()
+		default:
+			fmt.Fprintln(os.Stderr, usage)
+			os.Exit(1)
+		}
+	case "mfa":
+		usage := "Usage: caws mfa set-seed <profile-name> | remove-seed <profile-name>"
+		if len(args) != 3 {
+			fmt.Fprintln(os.Stderr, usage)
+			os.Exit(1)
+		}
+		switch args[1] {
+		case "set-seed":
+			err = handleMFASetSeed(args[2])
+		case "remove-seed":
+			err = handleMFARemoveSeed(args[2])
+		default:
+			fmt.Fprintln(os.Stderr, usage)
 			os.Exit(1)
 		}
-		err = handleExec(args[1], args[2:])
 	case "login":
+		fs := flag.NewFlagSet("login", flag.ExitOnError)
+		duration := fs.Duration("duration", 0, "lifetime of the console session, e.g. 1h")
+		service := fs.String("service", "", "open the console home of `service`, e.g. ec2")
+		path := fs.String("path", "", "open the console at `path`, e.g. /cloudwatch/home#logs:")
+		region := fs.String("region", "", "console region (defaults to the profile's region)")
+		open := fs.Bool("open", false, "open the URL in the system browser instead of printing it")
+		policy := addPolicyFlags(fs)
+		mfaToken := fs.String("mfa-token", "", "MFA `code` to use instead of prompting")
+		positional := parseCommandArgs(fs, args[1:])
+		if len(positional) != 1 {
+			fmt.Fprintln(os.Stderr, "Usage: caws login [--service <name> | --path <path>] [--region <region>] [--open] [--duration 12h] [--mfa-token <code>] [--policy <file>] [--policy-arn <arn>]... [--read-only] <profile-name>")
+			os.Exit(1)
+		}
+		err = handleLogin(positional[0], loginOptions{
+			duration: *duration,
+			service:  *service,
+			path:     *path,
+			region:   *region,
+			open:     *open,
+			policy:   *policy,
+			mfaToken: *mfaToken,
+		})
+	case "rotate":
 		if len(args) < 2 {
-			fmt.Fprintln(os.Stderr, "Usage: caws login <profile-name>")
+			fmt.Fprintln(os.Stderr, "Usage: caws rotate <profile-name>")
 			os.Exit(1)
 		}
-		err = handleLogin(args[1])
+		err = handleRotate(args[1])
 	case "remove", "rm":
 		if len(args) < 2 {
 			fmt.Fprintln(os.Stderr, "Usage: caws remove <profile-name>")
@@ -83,36 +237,112 @@ func main() {
 		os.Exit(1)
 	}
 
+	// A command run by caws exec failed; pass its status on
+	var status exitStatus
+	if errors.As(err, &status) {
+		os.Exit(int(status))
+	}
+
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
 	}
 }
 
+// parseCommandArgs parses subcommand flags that may appear before or after
+// positional arguments, returning the positional arguments in order
+func parseCommandArgs(fs *flag.FlagSet, args []string) []string {
+	var positional []string
+	for {
+		// ExitOnError flag sets exit on their own, so Parse cannot fail here
+		fs.Parse(args)
+		if fs.NArg() == 0 {
+			return positional
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
+
+// addPolicyFlags defines the session policy flags shared by exec and login
+func addPolicyFlags(fs *flag.FlagSet) *policyFlags {
+	policy := &policyFlags{}
+	fs.StringVar(&policy.file, "policy", "", "down-scope the session with the inline policy in `file`")
+	fs.Var(&policy.arns, "policy-arn", "down-scope the session with a managed policy `arn` (repeatable)")
+	fs.BoolVar(&policy.readOnly, "read-only", false, "down-scope the session to the ReadOnlyAccess managed policy")
+	return policy
+}
+
+// parseExecArgs parses exec flags given before or right after the profile.
+// Everything after "--" or the first non-flag argument is the command.
+func parseExecArgs(fs *flag.FlagSet, args []string) (string, []string) {
+	// ExitOnError flag sets exit on their own, so Parse cannot fail here
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		return "", nil
+	}
+
+	profile := fs.Arg(0)
+	fs.Parse(fs.Args()[1:])
+
+	return profile, fs.Args()
+}
+
 func printUsage() {
 	fmt.Println(`caws - Fast, local-first AWS credential manager
 
 Usage:
+  caws [--lock-timeout 30s] <command>  Wait for a vault locked by another caws
   caws init                            Initialize a new encrypted vault
+  caws passwd                          Change the vault password
+  caws upgrade                         Upgrade the vault to the current format
+  caws backup <file>                   Write an encrypted backup of the vault
+  caws restore <file> [--replace]      Restore profiles from a backup
   caws add <profile>                   Add AWS credentials for a profile
+  caws import                          Import keys from ~/.aws/credentials or IAM CSV
   caws list                            List available AWS profiles
+  caws config show <profile>           Show a profile's ~/.aws/config settings
+  caws config set <profile> <key> <value>
+                                       Set a setting (also get, unset)
   caws exec <profile>                  Spawn subshell with AWS credentials
   caws exec <profile> -- <command>     Execute command with AWS credentials
+  caws exec --server <profile> ...     Serve refreshing credentials to the command
+  caws exec --duration 8h <profile>    Request credentials valid for 8 hours
+  caws exec --read-only <profile>      Down-scope the session to read-only access
+  caws exec --exec-replace <profile> ...
+                                       Replace caws with the command (no process left)
+  caws export <profile>                Print credentials for credential_process
+  caws serve --imds <profile>          Serve credentials as a local EC2 metadata endpoint
+  caws agent                           Keep the vault unlocked for this session
+  caws mfa set-seed <profile>          Store the virtual MFA seed to generate codes
+  caws cache list                      List cached credentials and when they expire
+  caws cache clear [profile]           Remove cached credentials of a profile, or all
+  caws cache prune                     Remove expired and unreadable cached credentials
   caws login <profile>                 Generate AWS Console login URL
+  caws login --duration 1h <profile>   Console session valid for 1 hour
+  caws login --open <profile>          Open the console in the browser
+  caws rotate <profile>                Replace a profile's IAM access key
   caws remove <profile>                Remove a profile from vault
   caws version                         Show version
 
 Examples:
   caws init
   caws add production
+  caws config set production region eu-west-1
   caws exec production                 # Spawns shell with credentials
   caws exec production -- aws s3 ls    # Run single command
   caws login production | pbcopy       # Copy console URL to clipboard
+  caws login --service ec2 --open production  # Open the EC2 console
+  eval "$(caws agent)"                 # Enter the vault password once
+
+  # Use caws from any AWS SDK or CLI via ~/.aws/config:
+  [profile production-sdk]
+  credential_process = caws export production --format credential-process
 
 Credentials stored in:
   $XDG_DATA_HOME/caws/vault.enc (encrypted access keys, defaults to ~/.local/share/caws/vault.enc)
   $XDG_CACHE_HOME/caws/ (temporary credentials cache, defaults to ~/.cache/caws/)
-  ~/.aws/config (profile settings: region, MFA)
+  ~/.aws/config (profile settings: region, MFA, roles, SSO)
 
 Environment variables set:
   AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY, AWS_SESSION_TOKEN
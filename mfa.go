@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// maxMFAAttempts is how often an MFA code is obtained before giving up,
+// whether it was mistyped or rejected by STS
+const maxMFAAttempts = 3
+
+// mfaProcessTimeout bounds how long an mfa_process command may take,
+// leaving time for GUI prompts and hardware tokens that need a touch
+const mfaProcessTimeout = 2 * time.Minute
+
+// mfaProcessWaitDelay is how long output of mfa_process is still read after
+// the shell exited or was killed
+const mfaProcessWaitDelay = time.Second
+
+var mfaCodePattern = regexp.MustCompile(`^\d{6}$`)
+
+// errMFARejected means STS refused the MFA code as invalid or already used
+var errMFARejected = errors.New("MFA code was rejected")
+
+// mfaSource obtains the MFA codes of a base session. Codes come from, in
+// order: --mfa-token, a seed stored in the vault, the mfa_process command,
+// or a prompt on the terminal
+type mfaSource struct {
+	serial  string // mfa_serial, empty if no MFA is required
+	token   string // --mfa-token, used once
+	process string // mfa_process shell command
+}
+
+// code returns the MFA code for an attempt, counting from 1
+func (m mfaSource) code(seed string, attempt int) (string, error) {
+	switch {
+	case m.token != "":
+		if !mfaCodePattern.MatchString(m.token) {
+			return "", fmt.Errorf("invalid --mfa-token %q (expected 6 digits)", m.token)
+		}
+		return m.token, nil
+	case seed != "":
+		if attempt > 1 {
+			// STS refuses a code that was used before; wait for the next one
+			now := time.Now()
+			time.Sleep(now.Truncate(totpPeriod).Add(totpPeriod).Sub(now))
+		}
+		code, err := totpCode(seed, time.Now())
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintln(os.Stderr, "Generated MFA code from the stored seed")
+		return code, nil
+	case m.process != "":
+		return runMFAProcess(m.process)
+	default:
+		return readMFACode()
+	}
+}
+
+// retryable reports whether a rejected code can be replaced by a new one
+func (m mfaSource) retryable() bool {
+	return m.token == ""
+}
+
+// runMFAProcess runs the mfa_process command with the shell and returns the
+// code it prints. Its stderr is passed through for prompts and errors
+func runMFAProcess(command string) (string, error) {
+	fmt.Fprintln(os.Stderr, "Getting MFA code from mfa_process...")
+
+	ctx, cancel := context.WithTimeout(context.Background(), mfaProcessTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", command)
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = os.Stderr
+	// Only the shell is killed on timeout; processes it started (e.g. the
+	// left side of a pipeline) may keep stdout open, so stop waiting for them
+	cmd.WaitDelay = mfaProcessWaitDelay
+
+	err := cmd.Run()
+	switch {
+	case ctx.Err() != nil:
+		return "", fmt.Errorf("mfa_process did not finish within %s", mfaProcessTimeout)
+	case errors.Is(err, exec.ErrWaitDelay):
+		// The shell succeeded and left something running; its output is complete
+	case err != nil:
+		return "", fmt.Errorf("mfa_process failed: %w", err)
+	}
+
+	code := strings.TrimSpace(stdout.String())
+	if !mfaCodePattern.MatchString(code) {
+		return "", fmt.Errorf("mfa_process printed %q, expected a 6-digit MFA code", code)
+	}
+
+	return code, nil
+}
+
+// readMFACode prompts on the terminal until a 6-digit code is entered.
+// Stdin is never read, so it stays available to the command being run
+func readMFACode() (string, error) {
+	tty, err := openTTY()
+	if err != nil {
+		return "", fmt.Errorf("no terminal to prompt for an MFA code: use --mfa-token, mfa_process or 'caws mfa set-seed'")
+	}
+	tty.Close()
+
+	for attempt := 1; ; attempt++ {
+		code, err := readLineInput("Enter MFA code: ")
+		if err != nil {
+			return "", err
+		}
+		if mfaCodePattern.MatchString(code) {
+			return code, nil
+		}
+		if attempt == maxMFAAttempts {
+			return "", fmt.Errorf("invalid MFA code %q (expected 6 digits)", code)
+		}
+		fmt.Fprintln(os.Stderr, "MFA codes are 6 digits, try again")
+	}
+}
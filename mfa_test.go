@@ -0,0 +1,172 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeMFASTS is a local STS endpoint whose GetSessionToken accepts one MFA code
+type fakeMFASTS struct {
+	mu    sync.Mutex
+	valid string
+	calls int
+}
+
+func (f *fakeMFASTS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	r.ParseForm()
+	f.calls++
+
+	if r.Form.Get("TokenCode") != f.valid {
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, `<ErrorResponse><Error><Type>Sender</Type><Code>AccessDenied</Code><Message>MultiFactorAuthentication failed with invalid MFA one time pass code. </Message></Error></ErrorResponse>`)
+		return
+	}
+
+	fmt.Fprint(w, `<GetSessionTokenResponse><GetSessionTokenResult><Credentials><AccessKeyId>ASIAFAKESESSION00001</AccessKeyId><SecretAccessKey>sessionSecret</SecretAccessKey><SessionToken>sessionToken</SessionToken><Expiration>2099-01-01T00:00:00Z</Expiration></Credentials></GetSessionTokenResult></GetSessionTokenResponse>`)
+}
+
+func TestBaseSessionMFARetries(t *testing.T) {
+	// Prints 111111 on its first run and 222222 afterwards
+	counting := func(dir string) string {
+		counter := filepath.Join(dir, "runs")
+		return fmt.Sprintf(`n=$(cat %[1]s 2>/dev/null || echo 0); n=$((n+1)); echo $n > %[1]s; [ $n -ge 2 ] && echo 222222 || echo 111111`, counter)
+	}
+
+	tests := []struct {
+		name      string
+		mfa       func(dir string) mfaSource
+		wantCalls int
+		wantErr   bool
+	}{
+		{
+			name:      "process retried after rejection",
+			mfa:       func(dir string) mfaSource { return mfaSource{process: counting(dir)} },
+			wantCalls: 2,
+		},
+		{
+			name:      "token is not retried",
+			mfa:       func(dir string) mfaSource { return mfaSource{token: "111111", process: counting(dir)} },
+			wantCalls: 1,
+			wantErr:   true,
+		},
+		{
+			name:      "token format is checked",
+			mfa:       func(dir string) mfaSource { return mfaSource{token: "12345"} },
+			wantCalls: 0,
+			wantErr:   true,
+		},
+		{
+			name:      "gives up after three codes",
+			mfa:       func(dir string) mfaSource { return mfaSource{process: "echo 333333"} },
+			wantCalls: maxMFAAttempts,
+			wantErr:   true,
+		},
+		{
+			name:      "process output is checked",
+			mfa:       func(dir string) mfaSource { return mfaSource{process: "echo not-a-code"} },
+			wantCalls: 0,
+			wantErr:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			fake := &fakeMFASTS{valid: "222222"}
+			server := httptest.NewServer(fake)
+			defer server.Close()
+			t.Setenv("CAWS_STS_ENDPOINT", server.URL)
+			t.Setenv("CAWS_TEST_DIR", dir)
+			t.Setenv("CAWS_MOCK_STS", "")
+
+			vault := &vaultSession{creds: map[string]AWSCredentials{
+				"work": {AccessKeyID: "AKIAOLDKEY0000000001", SecretAccessKey: "secret"},
+			}}
+			mfa := tt.mfa(dir)
+			mfa.serial = "arn:aws:iam::123456789012:mfa/alice"
+
+			creds, err := getBaseSessionCredentials("work", &ConfigSettings{Region: "us-east-1"}, mfa, 0, vault)
+			if fake.calls != tt.wantCalls {
+				t.Errorf("GetSessionToken called %d times, want %d", fake.calls, tt.wantCalls)
+			}
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				if tt.wantCalls > 0 && !errors.Is(err, errMFARejected) {
+					t.Errorf("expected errMFARejected, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("getBaseSessionCredentials failed: %v", err)
+			}
+			if creds.AccessKeyID != "ASIAFAKESESSION00001" || creds.MFASerial != mfa.serial {
+				t.Errorf("unexpected credentials: %+v", creds)
+			}
+		})
+	}
+}
+
+func TestRunMFAProcessLingeringChild(t *testing.T) {
+	// The background sleep keeps stdout open after the shell exits
+	start := time.Now()
+	code, err := runMFAProcess("(sleep 30) 2>/dev/null & echo 123456")
+	if err != nil {
+		t.Fatalf("runMFAProcess failed: %v", err)
+	}
+	if code != "123456" {
+		t.Errorf("code = %q, want 123456", code)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("runMFAProcess waited %s for the background process", elapsed)
+	}
+}
+
+func TestRefreshingProviderMFAToken(t *testing.T) {
+	dir := t.TempDir()
+	fake := &fakeMFASTS{valid: "111111"}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	t.Setenv("CAWS_STS_ENDPOINT", server.URL)
+	t.Setenv("CAWS_TEST_DIR", dir)
+	t.Setenv("CAWS_MOCK_STS", "")
+
+	config := "[profile work]\nregion = us-east-1\nmfa_serial = arn:aws:iam::123456789012:mfa/alice\nmfa_process = echo 222222\n"
+	if err := os.WriteFile(filepath.Join(dir, "config"), []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	provider := newRefreshingProvider("work", sessionOptions{mfaToken: "111111"})
+	provider.vault = &vaultSession{creds: map[string]AWSCredentials{
+		"work": {AccessKeyID: "AKIAOLDKEY0000000001", SecretAccessKey: "secret"},
+	}}
+
+	if _, err := provider.Retrieve(); err != nil {
+		t.Fatalf("first Retrieve failed: %v", err)
+	}
+
+	// The base session expired; the used token must not be sent again
+	provider.creds = nil
+	if err := removeCachedCredentials("work"); err != nil {
+		t.Fatal(err)
+	}
+	fake.valid = "222222"
+
+	if _, err := provider.Retrieve(); err != nil {
+		t.Fatalf("second Retrieve failed: %v", err)
+	}
+	if fake.calls != 2 {
+		t.Errorf("GetSessionToken called %d times, want 2", fake.calls)
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sts/types"
+)
+
+const (
+	// Managed policy applied by --read-only
+	readOnlyPolicyARN = "arn:aws:iam::aws:policy/ReadOnlyAccess"
+
+	// STS limits for session policies
+	maxPolicyARNs         = 10
+	maxPolicyDocumentSize = 2048
+)
+
+var policyARNPattern = regexp.MustCompile(`^arn:aws[a-z-]*:iam::(\d{12}|aws):policy/[\w+=,.@/-]+$`)
+
+// stringList is a repeatable string flag
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// policyFlags holds the --policy, --policy-arn and --read-only flags
+type policyFlags struct {
+	file     string
+	arns     stringList
+	readOnly bool
+}
+
+// sessionPolicy down-scopes temporary credentials to the intersection of the
+// caller's permissions and the given policies
+type sessionPolicy struct {
+	document string   // compacted inline policy JSON, empty if none
+	arns     []string // managed policy ARNs, sorted
+}
+
+// load reads and validates the policies selected by the flags.
+// It returns nil when no policy was requested
+func (f policyFlags) load() (*sessionPolicy, error) {
+	arns := append([]string(nil), f.arns...)
+	if f.readOnly {
+		arns = append(arns, readOnlyPolicyARN)
+	}
+	if f.file == "" && len(arns) == 0 {
+		return nil, nil
+	}
+
+	policy := &sessionPolicy{}
+
+	if f.file != "" {
+		data, err := os.ReadFile(f.file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read policy: %w", err)
+		}
+
+		var compact bytes.Buffer
+		if err := json.Compact(&compact, data); err != nil {
+			return nil, fmt.Errorf("invalid policy %s: %w", f.file, err)
+		}
+		if compact.Len() > maxPolicyDocumentSize {
+			return nil, fmt.Errorf("policy %s is too large (%d characters, STS allows %d)", f.file, compact.Len(), maxPolicyDocumentSize)
+		}
+		policy.document = compact.String()
+	}
+
+	seen := make(map[string]bool)
+	for _, arn := range arns {
+		if !policyARNPattern.MatchString(arn) {
+			return nil, fmt.Errorf("invalid policy ARN: %s (expected arn:aws:iam::123456789012:policy/name)", arn)
+		}
+		if !seen[arn] {
+			seen[arn] = true
+			policy.arns = append(policy.arns, arn)
+		}
+	}
+	if len(policy.arns) > maxPolicyARNs {
+		return nil, fmt.Errorf("too many policy ARNs (%d, STS allows %d)", len(policy.arns), maxPolicyARNs)
+	}
+	sort.Strings(policy.arns)
+
+	return policy, nil
+}
+
+// hash identifies the policies in the credential cache, so down-scoped and
+// full sessions are never mixed up. It is empty for a nil policy
+func (p *sessionPolicy) hash() string {
+	if p == nil {
+		return ""
+	}
+
+	sum := sha256.New()
+	fmt.Fprintf(sum, "%s\n", p.document)
+	for _, arn := range p.arns {
+		fmt.Fprintf(sum, "%s\n", arn)
+	}
+	return hex.EncodeToString(sum.Sum(nil))[:16]
+}
+
+// stsParameters returns the Policy and PolicyArns parameters of an STS call
+func (p *sessionPolicy) stsParameters() (*string, []types.PolicyDescriptorType) {
+	if p == nil {
+		return nil, nil
+	}
+
+	var document *string
+	if p.document != "" {
+		document = aws.String(p.document)
+	}
+
+	var arns []types.PolicyDescriptorType
+	for _, arn := range p.arns {
+		arns = append(arns, types.PolicyDescriptorType{Arn: aws.String(arn)})
+	}
+
+	return document, arns
+}
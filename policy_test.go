@@ -0,0 +1,65 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPolicyFlagsLoad(t *testing.T) {
+	dir := t.TempDir()
+	policyFile := filepath.Join(dir, "policy.json")
+	os.WriteFile(policyFile, []byte(`{
+  "Version": "2012-10-17",
+  "Statement": [{"Effect": "Allow", "Action": "s3:Get*", "Resource": "*"}]
+}`), 0600)
+	badFile := filepath.Join(dir, "bad.json")
+	os.WriteFile(badFile, []byte(`{"Version": `), 0600)
+
+	// No flags means no policy
+	policy, err := policyFlags{}.load()
+	if err != nil || policy != nil {
+		t.Fatalf("empty flags: got %+v, %v", policy, err)
+	}
+
+	policy, err = policyFlags{
+		file:     policyFile,
+		arns:     stringList{"arn:aws:iam::123456789012:policy/Team", readOnlyPolicyARN},
+		readOnly: true,
+	}.load()
+	if err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+	if want := `{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Action":"s3:Get*","Resource":"*"}]}`; policy.document != want {
+		t.Errorf("document = %s, want %s", policy.document, want)
+	}
+	if len(policy.arns) != 2 || policy.arns[1] != readOnlyPolicyARN {
+		t.Errorf("arns should be sorted and deduplicated: %v", policy.arns)
+	}
+
+	for _, flags := range []policyFlags{
+		{file: badFile},
+		{file: filepath.Join(dir, "missing.json")},
+		{arns: stringList{"ReadOnlyAccess"}},
+	} {
+		if _, err := flags.load(); err == nil {
+			t.Errorf("load(%+v) should fail", flags)
+		}
+	}
+}
+
+func TestSessionPolicyHash(t *testing.T) {
+	var none *sessionPolicy
+	if none.hash() != "" {
+		t.Error("nil policy should have an empty hash")
+	}
+
+	readOnly := &sessionPolicy{arns: []string{readOnlyPolicyARN}}
+	inline := &sessionPolicy{document: `{"Version":"2012-10-17"}`}
+	if readOnly.hash() == "" || readOnly.hash() == inline.hash() {
+		t.Errorf("hashes should differ: %q, %q", readOnly.hash(), inline.hash())
+	}
+	if readOnly.hash() != (&sessionPolicy{arns: []string{readOnlyPolicyARN}}).hash() {
+		t.Error("hash should be stable")
+	}
+}
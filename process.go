@@ -0,0 +1,96 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// forwardedSignals are relayed to the command run by caws exec, so that
+// stopping or resizing caws reaches the command
+var forwardedSignals = []os.Signal{syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGWINCH}
+
+// exitStatus is returned by a command handler that must make caws exit with
+// a status other than 1, after its deferred cleanup has run
+type exitStatus int
+
+func (s exitStatus) Error() string {
+	return fmt.Sprintf("exit status %d", int(s))
+}
+
+// runCommand runs cmd in the foreground and returns its exit status. While
+// it runs, caws relays signals to it instead of being stopped by them. A
+// command killed by a signal gets the shell's status of 128+N
+func runCommand(cmd *exec.Cmd) (int, error) {
+	// Catch signals before starting, so none kills caws and orphans the command
+	signals := make(chan os.Signal, 8)
+	signal.Notify(signals, forwardedSignals...)
+	defer signal.Stop(signals)
+
+	if err := cmd.Start(); err != nil {
+		return 0, err
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case sig := <-signals:
+				forwardSignal(cmd.Process, sig)
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	err := cmd.Wait()
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return 0, err
+	}
+	if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return 128 + int(status.Signal()), nil
+	}
+	return exitErr.ExitCode(), nil
+}
+
+// forwardSignal relays a signal caught by caws to the command. The command
+// shares the process group of caws, so a Ctrl-C typed while caws runs in the
+// foreground of its terminal already reached it; sending it again would
+// count as a second interrupt (Terraform, for one, then stops immediately)
+func forwardSignal(process *os.Process, sig os.Signal) {
+	if sig == syscall.SIGINT && inForegroundGroup() {
+		return
+	}
+	// Fails only when the command has just exited
+	process.Signal(sig)
+}
+
+// inForegroundGroup reports whether caws runs in the foreground process
+// group of its controlling terminal, which receives the signals typed there
+func inForegroundGroup() bool {
+	tty, err := openTTY()
+	if err != nil {
+		return false
+	}
+	defer tty.Close()
+
+	pgrp, err := unix.IoctlGetInt(int(tty.Fd()), unix.TIOCGPGRP)
+	return err == nil && pgrp == unix.Getpgrp()
+}
+
+// replaceProcess executes cmd in place of caws, which then no longer exists
+// to wait for it. It returns only if the command cannot be executed
+func replaceProcess(cmd *exec.Cmd) error {
+	// exec.Command resolves the path, or records why it could not
+	if cmd.Err != nil {
+		return cmd.Err
+	}
+	return syscall.Exec(cmd.Path, cmd.Args, cmd.Env)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestRunCommandExitStatus(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		want   int
+	}{
+		{name: "success", script: "exit 0", want: 0},
+		{name: "exit code", script: "exit 3", want: 3},
+		{name: "killed by SIGTERM", script: "kill -TERM $$", want: 128 + 15},
+		{name: "killed by SIGKILL", script: "kill -KILL $$", want: 128 + 9},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, err := runCommand(exec.Command("/bin/sh", "-c", tt.script))
+			if err != nil {
+				t.Fatalf("runCommand failed: %v", err)
+			}
+			if status != tt.want {
+				t.Errorf("status = %d, want %d", status, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunCommandForwardsSignals(t *testing.T) {
+	ready := filepath.Join(t.TempDir(), "ready")
+	cmd := exec.Command("/bin/sh", "-c", `trap 'exit 42' TERM; touch "$1"; while :; do sleep 0.05; done`, "sh", ready)
+
+	go func() {
+		for i := 0; i < 100; i++ {
+			if _, err := os.Stat(ready); err == nil {
+				// Caught by runCommand, which relays it to the command
+				syscall.Kill(os.Getpid(), syscall.SIGTERM)
+				return
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+	}()
+
+	status, err := runCommand(cmd)
+	if err != nil {
+		t.Fatalf("runCommand failed: %v", err)
+	}
+	if status != 42 {
+		t.Errorf("status = %d, want 42 from the command's TERM trap", status)
+	}
+}
+
+func TestRunCommandNotFound(t *testing.T) {
+	if _, err := runCommand(exec.Command("caws-no-such-command")); err == nil {
+		t.Fatal("expected error for a missing command")
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	iamtypes "github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+const (
+	// How long a new access key may take to become usable
+	rotateVerifyTimeout  = 60 * time.Second
+	rotateVerifyInterval = 3 * time.Second
+)
+
+// newIAMClient creates an IAM client authenticated with long-term credentials
+// CAWS_IAM_ENDPOINT overrides the endpoint (e.g. for a local stub)
+func newIAMClient(ctx context.Context, creds *AWSCredentials) (*iam.Client, error) {
+	cfg, err := config.LoadDefaultConfig(ctx,
+		config.WithCredentialsProvider(
+			credentials.NewStaticCredentialsProvider(creds.AccessKeyID, creds.SecretAccessKey, ""),
+		),
+		config.WithRegion(creds.Region),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS config: %w", err)
+	}
+
+	return iam.NewFromConfig(cfg, func(o *iam.Options) {
+		if endpoint := os.Getenv("CAWS_IAM_ENDPOINT"); endpoint != "" {
+			o.BaseEndpoint = aws.String(endpoint)
+		}
+	}), nil
+}
+
+// getCallerARN returns the ARN of the IAM identity owning long-term credentials
+func getCallerARN(ctx context.Context, creds *AWSCredentials) (string, error) {
+	client, err := newSTSClient(ctx, creds.AccessKeyID, creds.SecretAccessKey, "", creds.Region)
+	if err != nil {
+		return "", err
+	}
+
+	result, err := client.GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
+	if err != nil {
+		return "", err
+	}
+	return aws.ToString(result.Arn), nil
+}
+
+// waitForAccessKey waits until STS accepts a new access key and checks that
+// it belongs to the expected identity. New keys take a few seconds to propagate
+func waitForAccessKey(ctx context.Context, creds *AWSCredentials, wantARN string) error {
+	deadline := time.Now().Add(rotateVerifyTimeout)
+	for {
+		arn, err := getCallerARN(ctx, creds)
+		if err == nil {
+			if arn != wantARN {
+				return fmt.Errorf("new access key belongs to %s instead of %s", arn, wantARN)
+			}
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("new access key was not accepted: %w", err)
+		}
+		time.Sleep(rotateVerifyInterval)
+	}
+}
+
+// rotateAccessKey replaces the IAM access key of a vault profile: it creates
+// a new key, verifies it, stores it in the vault, then deactivates and deletes
+// the old key. If any step fails, the completed steps are undone in reverse
+// order so that the old key remains active and in the vault
+func rotateAccessKey(store CredentialStore, profile string, oldCreds *AWSCredentials) (*AWSCredentials, error) {
+	ctx := context.Background()
+
+	identity, err := getCallerARN(ctx, oldCreds)
+	if err != nil {
+		return nil, fmt.Errorf("current access key of profile '%s' does not work: %w", profile, err)
+	}
+
+	oldClient, err := newIAMClient(ctx, oldCreds)
+	if err != nil {
+		return nil, err
+	}
+
+	created, err := oldClient.CreateAccessKey(ctx, &iam.CreateAccessKeyInput{})
+	if err != nil {
+		var limitExceeded *iamtypes.LimitExceededException
+		if errors.As(err, &limitExceeded) {
+			return nil, fmt.Errorf("%s already has two access keys; delete the unused one before rotating", identity)
+		}
+		return nil, fmt.Errorf("failed to create access key: %w", err)
+	}
+
+	newCreds := &AWSCredentials{
+		AccessKeyID:     aws.ToString(created.AccessKey.AccessKeyId),
+		SecretAccessKey: aws.ToString(created.AccessKey.SecretAccessKey),
+		Region:          oldCreds.Region,
+	}
+	fmt.Fprintf(os.Stderr, "✓ Created access key %s\n", newCreds.AccessKeyID)
+
+	// Undo steps for everything done so far, run in reverse order on failure
+	undo := []func() error{
+		func() error {
+			_, err := oldClient.DeleteAccessKey(ctx, &iam.DeleteAccessKeyInput{AccessKeyId: aws.String(newCreds.AccessKeyID)})
+			return err
+		},
+	}
+	rollback := func(cause error) error {
+		fmt.Fprintln(os.Stderr, "Rotation failed, rolling back...")
+		for i := len(undo) - 1; i >= 0; i-- {
+			if err := undo[i](); err != nil {
+				return fmt.Errorf("%w\nrollback failed: %v\nCheck the access keys of %s (old %s, new %s) in the IAM console",
+					cause, err, identity, oldCreds.AccessKeyID, newCreds.AccessKeyID)
+			}
+		}
+		fmt.Fprintf(os.Stderr, "✓ Rolled back; access key %s is still in use\n", oldCreds.AccessKeyID)
+		return cause
+	}
+
+	fmt.Fprintln(os.Stderr, "Verifying new access key...")
+	if err := waitForAccessKey(ctx, newCreds, identity); err != nil {
+		return nil, rollback(err)
+	}
+
+	if err := store.CreateCredentials(profile, newCreds.AccessKeyID, newCreds.SecretAccessKey); err != nil {
+		return nil, rollback(fmt.Errorf("failed to store new access key in vault: %w", err))
+	}
+	undo = append(undo, func() error {
+		return store.CreateCredentials(profile, oldCreds.AccessKeyID, oldCreds.SecretAccessKey)
+	})
+	fmt.Fprintln(os.Stderr, "✓ Stored new access key in vault")
+
+	// Retire the old key with the new one, which proves it can manage keys too
+	newClient, err := newIAMClient(ctx, newCreds)
+	if err != nil {
+		return nil, rollback(err)
+	}
+
+	_, err = newClient.UpdateAccessKey(ctx, &iam.UpdateAccessKeyInput{
+		AccessKeyId: aws.String(oldCreds.AccessKeyID),
+		Status:      iamtypes.StatusTypeInactive,
+	})
+	if err != nil {
+		return nil, rollback(fmt.Errorf("failed to deactivate old access key: %w", err))
+	}
+	undo = append(undo, func() error {
+		_, err := newClient.UpdateAccessKey(ctx, &iam.UpdateAccessKeyInput{
+			AccessKeyId: aws.String(oldCreds.AccessKeyID),
+			Status:      iamtypes.StatusTypeActive,
+		})
+		return err
+	})
+	fmt.Fprintf(os.Stderr, "✓ Deactivated access key %s\n", oldCreds.AccessKeyID)
+
+	_, err = newClient.DeleteAccessKey(ctx, &iam.DeleteAccessKeyInput{AccessKeyId: aws.String(oldCreds.AccessKeyID)})
+	if err != nil {
+		return nil, rollback(fmt.Errorf("failed to delete old access key: %w", err))
+	}
+	fmt.Fprintf(os.Stderr, "✓ Deleted access key %s\n", oldCreds.AccessKeyID)
+
+	return newCreds, nil
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sync"
+	"testing"
+)
+
+// fakeIAM is a local IAM and STS query API endpoint holding one user's keys
+type fakeIAM struct {
+	mu      sync.Mutex
+	keys    map[string]string // access key ID -> "Active" or "Inactive"
+	created int
+	fail    string // action that fails once with an error
+}
+
+var credentialPattern = regexp.MustCompile(`Credential=([^/]+)/`)
+
+func (f *fakeIAM) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	r.ParseForm()
+	action := r.Form.Get("Action")
+
+	caller := ""
+	if m := credentialPattern.FindStringSubmatch(r.Header.Get("Authorization")); m != nil {
+		caller = m[1]
+	}
+	if f.keys[caller] != "Active" {
+		writeQueryError(w, http.StatusForbidden, "InvalidClientTokenId")
+		return
+	}
+	if action == f.fail {
+		f.fail = ""
+		writeQueryError(w, http.StatusForbidden, "AccessDenied")
+		return
+	}
+
+	switch action {
+	case "GetCallerIdentity":
+		fmt.Fprint(w, `<GetCallerIdentityResponse><GetCallerIdentityResult><Arn>arn:aws:iam::123456789012:user/alice</Arn><UserId>AIDAEXAMPLE</UserId><Account>123456789012</Account></GetCallerIdentityResult></GetCallerIdentityResponse>`)
+	case "CreateAccessKey":
+		f.created++
+		keyID := fmt.Sprintf("AKIANEWKEY%010d", f.created)
+		f.keys[keyID] = "Active"
+		fmt.Fprintf(w, `<CreateAccessKeyResponse><CreateAccessKeyResult><AccessKey><UserName>alice</UserName><AccessKeyId>%s</AccessKeyId><Status>Active</Status><SecretAccessKey>newSecret</SecretAccessKey></AccessKey></CreateAccessKeyResult></CreateAccessKeyResponse>`, keyID)
+	case "UpdateAccessKey":
+		f.keys[r.Form.Get("AccessKeyId")] = r.Form.Get("Status")
+		fmt.Fprint(w, `<UpdateAccessKeyResponse></UpdateAccessKeyResponse>`)
+	case "DeleteAccessKey":
+		delete(f.keys, r.Form.Get("AccessKeyId"))
+		fmt.Fprint(w, `<DeleteAccessKeyResponse></DeleteAccessKeyResponse>`)
+	default:
+		writeQueryError(w, http.StatusBadRequest, "InvalidAction")
+	}
+}
+
+func writeQueryError(w http.ResponseWriter, status int, code string) {
+	w.WriteHeader(status)
+	fmt.Fprintf(w, `<ErrorResponse><Error><Type>Sender</Type><Code>%s</Code><Message>%s</Message></Error></ErrorResponse>`, code, code)
+}
+
+// memoryStore is an in-memory CredentialStore
+type memoryStore struct {
+	profiles   map[string]ProfileData
+	failCreate bool
+}
+
+func (m *memoryStore) GetCredentials(profile string) (*AWSCredentials, error) {
+	data, ok := m.profiles[profile]
+	if !ok {
+		return nil, fmt.Errorf("profile '%s' not found in vault", profile)
+	}
+	return &AWSCredentials{AccessKeyID: data.AccessKey, SecretAccessKey: data.SecretKey}, nil
+}
+
+func (m *memoryStore) CreateCredentials(profile, accessKey, secretKey string) error {
+	if m.failCreate {
+		return errors.New("vault is read-only")
+	}
+	m.profiles[profile] = ProfileData{AccessKey: accessKey, SecretKey: secretKey}
+	return nil
+}
+
+func (m *memoryStore) ListProfiles() ([]ProfileInfo, error)                { return nil, nil }
+func (m *memoryStore) RemoveProfile(profile string) error                  { return nil }
+func (m *memoryStore) SetMFASeed(profile, seed string) error               { return nil }
+func (m *memoryStore) GetSSOToken(session string) (*SSOToken, error)       { return nil, nil }
+func (m *memoryStore) StoreSSOToken(session string, token *SSOToken) error { return nil }
+func (m *memoryStore) Close() error                                        { return nil }
+
+func TestRotateAccessKey(t *testing.T) {
+	const oldKey = "AKIAOLDKEY0000000001"
+
+	tests := []struct {
+		name       string
+		fail       string
+		failCreate bool
+		wantErr    bool
+	}{
+		{name: "success"},
+		{name: "create fails", fail: "CreateAccessKey", wantErr: true},
+		{name: "vault write fails", failCreate: true, wantErr: true},
+		{name: "deactivate fails", fail: "UpdateAccessKey", wantErr: true},
+		{name: "delete fails", fail: "DeleteAccessKey", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeIAM{keys: map[string]string{oldKey: "Active"}, fail: tt.fail}
+			server := httptest.NewServer(fake)
+			defer server.Close()
+			t.Setenv("CAWS_IAM_ENDPOINT", server.URL)
+			t.Setenv("CAWS_STS_ENDPOINT", server.URL)
+
+			store := &memoryStore{profiles: map[string]ProfileData{
+				"work": {AccessKey: oldKey, SecretKey: "oldSecret"},
+			}}
+			store.failCreate = tt.failCreate
+
+			oldCreds := &AWSCredentials{AccessKeyID: oldKey, SecretAccessKey: "oldSecret", Region: "us-east-1"}
+			newCreds, err := rotateAccessKey(store, "work", oldCreds)
+
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				// Rolled back: only the old key exists, is active and in the vault
+				if len(fake.keys) != 1 || fake.keys[oldKey] != "Active" {
+					t.Errorf("IAM keys after rollback: %v", fake.keys)
+				}
+				if store.profiles["work"].AccessKey != oldKey {
+					t.Errorf("vault key after rollback: %s", store.profiles["work"].AccessKey)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("rotateAccessKey failed: %v", err)
+			}
+			if len(fake.keys) != 1 || fake.keys[newCreds.AccessKeyID] != "Active" {
+				t.Errorf("IAM keys after rotation: %v", fake.keys)
+			}
+			if got := store.profiles["work"]; got.AccessKey != newCreds.AccessKeyID || got.SecretKey != "newSecret" {
+				t.Errorf("vault after rotation: %+v", got)
+			}
+		})
+	}
+}
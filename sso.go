@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/sso"
+	ssotypes "github.com/aws/aws-sdk-go-v2/service/sso/types"
+	"github.com/aws/aws-sdk-go-v2/service/ssooidc"
+	oidctypes "github.com/aws/aws-sdk-go-v2/service/ssooidc/types"
+)
+
+const (
+	ssoClientName       = "caws"
+	ssoDeviceGrantType  = "urn:ietf:params:oauth:grant-type:device_code"
+	ssoRefreshGrantType = "refresh_token"
+
+	// Polling interval when the OIDC service does not suggest one
+	ssoDefaultPollInterval = 5 * time.Second
+)
+
+// SSOToken is an IAM Identity Center access token together with the OIDC
+// client registration used to obtain and refresh it
+type SSOToken struct {
+	StartURL              string    `json:"start_url"`
+	Region                string    `json:"region"`
+	AccessToken           string    `json:"access_token"`
+	ExpiresAt             time.Time `json:"expires_at"`
+	RefreshToken          string    `json:"refresh_token,omitempty"`
+	ClientID              string    `json:"client_id"`
+	ClientSecret          string    `json:"client_secret"`
+	ClientSecretExpiresAt time.Time `json:"client_secret_expires_at"`
+}
+
+// valid reports whether the access token can still be used
+func (t *SSOToken) valid() bool {
+	return time.Now().Add(cacheExpiryBuffer).Before(t.ExpiresAt)
+}
+
+// clientValid reports whether the client registration can still be used
+func (t *SSOToken) clientValid() bool {
+	return t.ClientID != "" && time.Now().Add(cacheExpiryBuffer).Before(t.ClientSecretExpiresAt)
+}
+
+// ssoSessionKey returns the name a profile's SSO token is stored under:
+// the sso_session name, or the start URL for legacy profiles without one
+func ssoSessionKey(settings *ConfigSettings) string {
+	if settings.SSOSession != "" {
+		return settings.SSOSession
+	}
+	return settings.SSOStartURL
+}
+
+// loadSSOConfig loads an anonymous AWS config; the OIDC and portal APIs
+// authenticate with client secrets and bearer tokens instead of SigV4
+func loadSSOConfig(ctx context.Context, region string) (aws.Config, error) {
+	cfg, err := config.LoadDefaultConfig(ctx,
+		config.WithCredentialsProvider(aws.AnonymousCredentials{}),
+		config.WithRegion(region),
+	)
+	if err != nil {
+		return aws.Config{}, fmt.Errorf("failed to load AWS config: %w", err)
+	}
+	return cfg, nil
+}
+
+// newSSOOIDCClient creates an IAM Identity Center OIDC client
+// CAWS_SSO_OIDC_ENDPOINT overrides the endpoint (e.g. for a local fake)
+func newSSOOIDCClient(ctx context.Context, region string) (*ssooidc.Client, error) {
+	cfg, err := loadSSOConfig(ctx, region)
+	if err != nil {
+		return nil, err
+	}
+
+	return ssooidc.NewFromConfig(cfg, func(o *ssooidc.Options) {
+		if endpoint := os.Getenv("CAWS_SSO_OIDC_ENDPOINT"); endpoint != "" {
+			o.BaseEndpoint = aws.String(endpoint)
+		}
+	}), nil
+}
+
+// newSSOPortalClient creates an IAM Identity Center portal client
+// CAWS_SSO_PORTAL_ENDPOINT overrides the endpoint (e.g. for a local fake)
+func newSSOPortalClient(ctx context.Context, region string) (*sso.Client, error) {
+	cfg, err := loadSSOConfig(ctx, region)
+	if err != nil {
+		return nil, err
+	}
+
+	return sso.NewFromConfig(cfg, func(o *sso.Options) {
+		if endpoint := os.Getenv("CAWS_SSO_PORTAL_ENDPOINT"); endpoint != "" {
+			o.BaseEndpoint = aws.String(endpoint)
+		}
+	}), nil
+}
+
+// SSODeviceLogin signs in to IAM Identity Center with the OIDC device
+// authorization flow. The user approves access in a browser while caws
+// polls for the token. A previous token's client registration is reused
+func SSODeviceLogin(settings *ConfigSettings, previous *SSOToken) (*SSOToken, error) {
+	ctx := context.Background()
+
+	client, err := newSSOOIDCClient(ctx, settings.SSORegion)
+	if err != nil {
+		return nil, err
+	}
+
+	token := &SSOToken{
+		StartURL: settings.SSOStartURL,
+		Region:   settings.SSORegion,
+	}
+
+	if previous != nil && previous.clientValid() && previous.Region == settings.SSORegion {
+		token.ClientID = previous.ClientID
+		token.ClientSecret = previous.ClientSecret
+		token.ClientSecretExpiresAt = previous.ClientSecretExpiresAt
+	} else {
+		registration, err := client.RegisterClient(ctx, &ssooidc.RegisterClientInput{
+			ClientName: aws.String(ssoClientName),
+			ClientType: aws.String("public"),
+			Scopes:     settings.SSORegistrationScopes,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to register OIDC client: %w", err)
+		}
+		token.ClientID = aws.ToString(registration.ClientId)
+		token.ClientSecret = aws.ToString(registration.ClientSecret)
+		token.ClientSecretExpiresAt = time.Unix(registration.ClientSecretExpiresAt, 0)
+	}
+
+	authorization, err := client.StartDeviceAuthorization(ctx, &ssooidc.StartDeviceAuthorizationInput{
+		ClientId:     aws.String(token.ClientID),
+		ClientSecret: aws.String(token.ClientSecret),
+		StartUrl:     aws.String(settings.SSOStartURL),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to start device authorization: %w", err)
+	}
+
+	verificationURL := aws.ToString(authorization.VerificationUriComplete)
+	if verificationURL == "" {
+		verificationURL = aws.ToString(authorization.VerificationUri)
+	}
+	fmt.Fprintf(os.Stderr, "To sign in to IAM Identity Center, open this URL in a browser:\n\n  %s\n\nand confirm the code: %s\n\nWaiting for approval...\n", verificationURL, aws.ToString(authorization.UserCode))
+
+	interval := time.Duration(authorization.Interval) * time.Second
+	if interval <= 0 {
+		interval = ssoDefaultPollInterval
+	}
+	deadline := time.Now().Add(time.Duration(authorization.ExpiresIn) * time.Second)
+
+	for {
+		time.Sleep(interval)
+
+		result, err := client.CreateToken(ctx, &ssooidc.CreateTokenInput{
+			ClientId:     aws.String(token.ClientID),
+			ClientSecret: aws.String(token.ClientSecret),
+			GrantType:    aws.String(ssoDeviceGrantType),
+			DeviceCode:   authorization.DeviceCode,
+		})
+		if err == nil {
+			token.AccessToken = aws.ToString(result.AccessToken)
+			token.ExpiresAt = time.Now().Add(time.Duration(result.ExpiresIn) * time.Second)
+			token.RefreshToken = aws.ToString(result.RefreshToken)
+			fmt.Fprintln(os.Stderr, "✓ Signed in to IAM Identity Center")
+			return token, nil
+		}
+
+		var pending *oidctypes.AuthorizationPendingException
+		var slowDown *oidctypes.SlowDownException
+		var expired *oidctypes.ExpiredTokenException
+		var denied *oidctypes.AccessDeniedException
+		switch {
+		case errors.As(err, &pending):
+		case errors.As(err, &slowDown):
+			interval += ssoDefaultPollInterval
+		case errors.As(err, &expired):
+			return nil, fmt.Errorf("device authorization expired before it was approved")
+		case errors.As(err, &denied):
+			return nil, fmt.Errorf("sign-in was denied")
+		default:
+			return nil, fmt.Errorf("failed to create SSO token: %w", err)
+		}
+
+		if authorization.ExpiresIn > 0 && time.Now().After(deadline) {
+			return nil, fmt.Errorf("device authorization expired before it was approved")
+		}
+	}
+}
+
+// RefreshSSOToken exchanges a token's refresh token for a new access token
+func RefreshSSOToken(token *SSOToken) (*SSOToken, error) {
+	if token.RefreshToken == "" || !token.clientValid() {
+		return nil, fmt.Errorf("token cannot be refreshed")
+	}
+
+	ctx := context.Background()
+
+	client, err := newSSOOIDCClient(ctx, token.Region)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := client.CreateToken(ctx, &ssooidc.CreateTokenInput{
+		ClientId:     aws.String(token.ClientID),
+		ClientSecret: aws.String(token.ClientSecret),
+		GrantType:    aws.String(ssoRefreshGrantType),
+		RefreshToken: aws.String(token.RefreshToken),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to refresh SSO token: %w", err)
+	}
+
+	refreshed := *token
+	refreshed.AccessToken = aws.ToString(result.AccessToken)
+	refreshed.ExpiresAt = time.Now().Add(time.Duration(result.ExpiresIn) * time.Second)
+	if result.RefreshToken != nil {
+		refreshed.RefreshToken = aws.ToString(result.RefreshToken)
+	}
+
+	return &refreshed, nil
+}
+
+// errSSOUnauthorized means the portal rejected the access token
+var errSSOUnauthorized = errors.New("IAM Identity Center access token was rejected")
+
+// GetSSORoleCredentials fetches temporary credentials for an account role
+// from the IAM Identity Center portal
+func GetSSORoleCredentials(token *SSOToken, accountID, roleName string) (*STSCredentials, error) {
+	ctx := context.Background()
+
+	client, err := newSSOPortalClient(ctx, token.Region)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := client.GetRoleCredentials(ctx, &sso.GetRoleCredentialsInput{
+		AccessToken: aws.String(token.AccessToken),
+		AccountId:   aws.String(accountID),
+		RoleName:    aws.String(roleName),
+	})
+	if err != nil {
+		var unauthorized *ssotypes.UnauthorizedException
+		if errors.As(err, &unauthorized) {
+			return nil, errSSOUnauthorized
+		}
+		return nil, fmt.Errorf("failed to get role credentials: %w", err)
+	}
+
+	roleCreds := result.RoleCredentials
+	if roleCreds == nil {
+		return nil, fmt.Errorf("IAM Identity Center returned no credentials")
+	}
+
+	return &STSCredentials{
+		AccessKeyID:     aws.ToString(roleCreds.AccessKeyId),
+		SecretAccessKey: aws.ToString(roleCreds.SecretAccessKey),
+		SessionToken:    aws.ToString(roleCreds.SessionToken),
+		Expiration:      time.UnixMilli(roleCreds.Expiration),
+		Type:            "sso",
+		SSORole:         accountID + "/" + roleName,
+	}, nil
+}
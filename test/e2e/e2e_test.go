@@ -1,15 +1,29 @@
 package e2e
 
 import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
 	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
+	"syscall"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/argon2"
 )
 
 // Package-level variable for the caws binary path
@@ -17,6 +31,11 @@ var cawsBin string
 
 // TestMain builds the caws binary once before running all tests
 func TestMain(m *testing.M) {
+	// Re-executed by StartLockHolder to hold the vault lock in another process
+	if lockPath := os.Getenv("CAWS_E2E_HOLD_LOCK"); lockPath != "" {
+		holdVaultLock(lockPath)
+	}
+
 	// Build binary once for all tests
 	tmpDir, err := os.MkdirTemp("", "caws-e2e-bin-")
 	if err != nil {
@@ -137,6 +156,33 @@ func (e *TestEnv) Command(args ...string) *exec.Cmd {
 	return cmd
 }
 
+// StartServer starts a long-running caws command and waits for the stderr line
+// announcing it, returning the URL printed on that line. The process is
+// killed when the test ends.
+func (e *TestEnv) StartServer(cmd *exec.Cmd, banner string) string {
+	stderr, err := cmd.StderrPipe()
+	require.NoError(e.t, err)
+	require.NoError(e.t, cmd.Start(), "server should start")
+	e.t.Cleanup(func() { cmd.Process.Kill() })
+
+	scanner := bufio.NewScanner(stderr)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.Contains(line, banner) {
+			continue
+		}
+		idx := strings.Index(line, "http://")
+		require.GreaterOrEqual(e.t, idx, 0, "banner should contain a URL: %s", line)
+
+		// Keep draining stderr so the server never blocks on writes
+		go io.Copy(io.Discard, stderr)
+		return line[idx:]
+	}
+
+	e.t.Fatalf("server exited before printing %q", banner)
+	return ""
+}
+
 // SetupVault initializes a vault
 func (e *TestEnv) SetupVault() {
 	e.MustRun("init")
@@ -185,9 +231,30 @@ func (e *TestEnv) VaultPath() string {
 	return filepath.Join(e.Dir, "vault.enc")
 }
 
-// CachePath returns the path to a profile's cache file
-func (e *TestEnv) CachePath(profile string) string {
-	return filepath.Join(e.Dir, "cache", profile+".json")
+// CachePath returns the path to a profile's cache entry, e.g. "session"
+func (e *TestEnv) CachePath(profile, entry string) string {
+	return filepath.Join(e.Dir, "cache", profile, entry+".json")
+}
+
+// CacheEntries returns the paths of a profile's cache entries, newest first
+func (e *TestEnv) CacheEntries(profile string) []string {
+	paths, err := filepath.Glob(filepath.Join(e.Dir, "cache", profile, "*.json"))
+	require.NoError(e.t, err)
+
+	modified := make(map[string]time.Time)
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		require.NoError(e.t, err)
+		modified[path] = info.ModTime()
+	}
+	sort.Slice(paths, func(i, j int) bool { return modified[paths[i]].After(modified[paths[j]]) })
+
+	return paths
+}
+
+// ClearCache removes all cache entries of a profile
+func (e *TestEnv) ClearCache(profile string) {
+	require.NoError(e.t, os.RemoveAll(filepath.Join(e.Dir, "cache", profile)))
 }
 
 // LockPath returns the path to the vault lock file
@@ -195,16 +262,69 @@ func (e *TestEnv) LockPath() string {
 	return filepath.Join(e.Dir, "vault.enc.lock")
 }
 
+// HoldVaultLock locks the vault like another caws process would, shared or
+// exclusive, and returns a function releasing the lock
+func (e *TestEnv) HoldVaultLock(exclusive bool) func() {
+	lockFile, err := os.OpenFile(e.LockPath(), os.O_CREATE|os.O_RDWR, 0600)
+	require.NoError(e.t, err)
+
+	how := syscall.LOCK_SH
+	if exclusive {
+		how = syscall.LOCK_EX
+	}
+	require.NoError(e.t, syscall.Flock(int(lockFile.Fd()), how|syscall.LOCK_NB))
+	fmt.Fprintf(lockFile, "%d flock\n", os.Getpid())
+
+	return func() {
+		os.Remove(e.LockPath())
+		lockFile.Close()
+	}
+}
+
+// StartLockHolder starts a process holding the vault lock exclusively, the
+// way a caws process writing the vault does, until it is killed
+func (e *TestEnv) StartLockHolder() *exec.Cmd {
+	cmd := exec.Command(os.Args[0])
+	cmd.Env = append(os.Environ(), "CAWS_E2E_HOLD_LOCK="+e.LockPath())
+	stdout, err := cmd.StdoutPipe()
+	require.NoError(e.t, err)
+	require.NoError(e.t, cmd.Start())
+	e.t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+
+	line, err := bufio.NewReader(stdout).ReadString('\n')
+	require.NoError(e.t, err, "lock holder failed to start")
+	require.Equal(e.t, "locked\n", line)
+	return cmd
+}
+
+// holdVaultLock locks lockPath exclusively, reports it on stdout and waits
+// to be killed
+func holdVaultLock(lockPath string) {
+	lockFile, err := os.OpenFile(lockPath, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		panic(err)
+	}
+	if err := syscall.Flock(int(lockFile.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		panic(err)
+	}
+	fmt.Fprintf(lockFile, "%d flock\n", os.Getpid())
+	fmt.Println("locked")
+	time.Sleep(time.Hour)
+	os.Exit(0)
+}
+
 // VaultExists checks if vault file exists
 func (e *TestEnv) VaultExists() bool {
 	_, err := os.Stat(e.VaultPath())
 	return err == nil
 }
 
-// CacheExists checks if a profile's cache file exists
+// CacheExists checks if a profile has cache entries
 func (e *TestEnv) CacheExists(profile string) bool {
-	_, err := os.Stat(e.CachePath(profile))
-	return err == nil
+	return len(e.CacheEntries(profile)) > 0
 }
 
 // LockFileExists checks if lock file exists
@@ -213,19 +333,231 @@ func (e *TestEnv) LockFileExists() bool {
 	return err == nil
 }
 
-// ReadCache reads and parses a profile's cache file
+// CacheKeyPath returns the path to the cache key (test mode keeps it in a file)
+func (e *TestEnv) CacheKeyPath() string {
+	return filepath.Join(e.Dir, "cache.key")
+}
+
+// ReadCache decrypts and parses a profile's most recently written cache entry
 func (e *TestEnv) ReadCache(profile string) map[string]interface{} {
-	data, err := os.ReadFile(e.CachePath(profile))
+	entries := e.CacheEntries(profile)
+	require.NotEmpty(e.t, entries, "no cache entries for %s", profile)
+	return e.ReadCacheEntry(profile, entries[0])
+}
+
+// ReadCacheEntry decrypts and parses a cache entry file of a profile
+func (e *TestEnv) ReadCacheEntry(profile, path string) map[string]interface{} {
+	data, err := os.ReadFile(path)
 	require.NoError(e.t, err, "failed to read cache file")
 
+	var file struct {
+		Version int    `json:"version"`
+		Nonce   string `json:"nonce"`
+		Data    string `json:"data"`
+	}
+	require.NoError(e.t, json.Unmarshal(data, &file), "failed to parse cache JSON")
+	require.Equal(e.t, 1, file.Version, "unexpected cache version")
+
+	key, err := os.ReadFile(e.CacheKeyPath())
+	require.NoError(e.t, err, "failed to read cache key")
+	nonce, err := base64.StdEncoding.DecodeString(file.Nonce)
+	require.NoError(e.t, err)
+	ciphertext, err := base64.StdEncoding.DecodeString(file.Data)
+	require.NoError(e.t, err)
+
+	block, err := aes.NewCipher(key)
+	require.NoError(e.t, err)
+	gcm, err := cipher.NewGCM(block)
+	require.NoError(e.t, err)
+	entry := profile + "/" + strings.TrimSuffix(filepath.Base(path), ".json")
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, []byte(entry))
+	require.NoError(e.t, err, "failed to decrypt cache file")
+
 	var cache map[string]interface{}
-	require.NoError(e.t, json.Unmarshal(data, &cache), "failed to parse cache JSON")
+	require.NoError(e.t, json.Unmarshal(plaintext, &cache), "failed to parse cached credentials")
 	return cache
 }
 
+// ReadVaultHeader reads the unencrypted fields of the vault file
+func (e *TestEnv) ReadVaultHeader() map[string]interface{} {
+	data, err := os.ReadFile(e.VaultPath())
+	require.NoError(e.t, err, "failed to read vault file")
+
+	var header map[string]interface{}
+	require.NoError(e.t, json.Unmarshal(data, &header), "failed to parse vault JSON")
+	delete(header, "data")
+	return header
+}
+
+// WriteV1Vault writes a vault in the original v1 format (fixed Argon2id
+// parameters, no KDF header) holding a single profile
+func (e *TestEnv) WriteV1Vault(password, profile, accessKey, secretKey string) {
+	salt := make([]byte, 32)
+	_, err := rand.Read(salt)
+	require.NoError(e.t, err)
+
+	key := argon2.IDKey([]byte(password), salt, 1, 64*1024, 4, 32)
+	block, err := aes.NewCipher(key)
+	require.NoError(e.t, err)
+	gcm, err := cipher.NewGCM(block)
+	require.NoError(e.t, err)
+
+	nonce := make([]byte, gcm.NonceSize())
+	_, err = rand.Read(nonce)
+	require.NoError(e.t, err)
+
+	plaintext, err := json.Marshal(map[string]interface{}{
+		"profiles": map[string]interface{}{
+			profile: map[string]string{"access_key": accessKey, "secret_key": secretKey},
+		},
+	})
+	require.NoError(e.t, err)
+
+	vault, err := json.Marshal(map[string]interface{}{
+		"version": 1,
+		"salt":    base64.StdEncoding.EncodeToString(salt),
+		"nonce":   base64.StdEncoding.EncodeToString(nonce),
+		"data":    base64.StdEncoding.EncodeToString(gcm.Seal(nil, nonce, plaintext, nil)),
+	})
+	require.NoError(e.t, err)
+	require.NoError(e.t, os.WriteFile(e.VaultPath(), vault, 0600))
+}
+
 // AssertVaultPermissions verifies vault has 0600 permissions
 func (e *TestEnv) AssertVaultPermissions() {
 	info, err := os.Stat(e.VaultPath())
 	require.NoError(e.t, err)
 	assert.Equal(e.t, os.FileMode(0600), info.Mode().Perm(), "vault permissions should be 0600")
 }
+
+// FakeSSO is a local IAM Identity Center OIDC and portal endpoint.
+// Device authorizations are approved on the second token poll.
+type FakeSSO struct {
+	URL string
+
+	mu           sync.Mutex
+	logins       int             // completed device authorizations
+	polls        map[string]int  // device code -> token polls
+	accessTokens map[string]bool // valid access tokens
+}
+
+// NewFakeSSO starts a fake IAM Identity Center server for the test
+func NewFakeSSO(t *testing.T) *FakeSSO {
+	f := &FakeSSO{
+		polls:        make(map[string]int),
+		accessTokens: make(map[string]bool),
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(f.serveHTTP))
+	t.Cleanup(server.Close)
+	f.URL = server.URL
+	return f
+}
+
+// Env returns the environment pointing caws at the fake server
+func (f *FakeSSO) Env() []string {
+	return []string{
+		"CAWS_SSO_OIDC_ENDPOINT=" + f.URL,
+		"CAWS_SSO_PORTAL_ENDPOINT=" + f.URL,
+	}
+}
+
+// Logins returns the number of completed device authorizations
+func (f *FakeSSO) Logins() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.logins
+}
+
+// RevokeTokens invalidates all issued access tokens
+func (f *FakeSSO) RevokeTokens() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.accessTokens = make(map[string]bool)
+}
+
+func (f *FakeSSO) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var body map[string]interface{}
+	if r.Method == http.MethodPost {
+		json.NewDecoder(r.Body).Decode(&body)
+	}
+
+	now := time.Now().Unix()
+	switch r.URL.Path {
+	case "/client/register":
+		writeFakeJSON(w, map[string]interface{}{
+			"clientId":              "fake-client",
+			"clientSecret":          "fake-secret",
+			"clientIdIssuedAt":      now,
+			"clientSecretExpiresAt": now + 90*24*3600,
+		})
+	case "/device_authorization":
+		deviceCode := fmt.Sprintf("device-%d", len(f.polls)+1)
+		f.polls[deviceCode] = 0
+		writeFakeJSON(w, map[string]interface{}{
+			"deviceCode":              deviceCode,
+			"userCode":                "ABCD-EFGH",
+			"verificationUri":         "https://device.sso.example.com/",
+			"verificationUriComplete": "https://device.sso.example.com/?user_code=ABCD-EFGH",
+			"expiresIn":               600,
+			"interval":                1,
+		})
+	case "/token":
+		if body["grantType"] == "refresh_token" {
+			f.issueToken(w)
+			return
+		}
+		deviceCode, _ := body["deviceCode"].(string)
+		f.polls[deviceCode]++
+		if f.polls[deviceCode] < 2 {
+			writeFakeError(w, http.StatusBadRequest, "AuthorizationPendingException")
+			return
+		}
+		f.logins++
+		f.issueToken(w)
+	case "/federation/credentials":
+		if !f.accessTokens[r.Header.Get("x-amz-sso_bearer_token")] {
+			writeFakeError(w, http.StatusUnauthorized, "UnauthorizedException")
+			return
+		}
+		writeFakeJSON(w, map[string]interface{}{
+			"roleCredentials": map[string]interface{}{
+				"accessKeyId":     "ASIAFAKESSO" + r.URL.Query().Get("account_id")[:9],
+				"secretAccessKey": "fakeSSOSecretKey1234567890",
+				"sessionToken":    "fakeSSOSessionToken-" + r.URL.Query().Get("role_name"),
+				"expiration":      time.Now().Add(time.Hour).UnixMilli(),
+			},
+		})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// issueToken issues a new access token (caller holds f.mu)
+func (f *FakeSSO) issueToken(w http.ResponseWriter) {
+	token := fmt.Sprintf("access-token-%d", len(f.accessTokens)+f.logins+1)
+	f.accessTokens[token] = true
+	writeFakeJSON(w, map[string]interface{}{
+		"accessToken":  token,
+		"tokenType":    "Bearer",
+		"expiresIn":    3600,
+		"refreshToken": "refresh-token",
+	})
+}
+
+// writeFakeJSON writes a JSON response from a fake AWS service
+func writeFakeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeFakeError writes an AWS REST-JSON error response
+func writeFakeError(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Amzn-ErrorType", code)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"message": code})
+}
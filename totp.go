@@ -0,0 +1,75 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Time step of AWS virtual MFA devices, which use the RFC 6238 defaults:
+// HMAC-SHA1 and 6-digit codes
+const totpPeriod = 30 * time.Second
+
+// parseTOTPSeed normalizes and validates the base32 secret shown when a
+// virtual MFA device is assigned. An otpauth:// URI from the QR code is
+// accepted as well
+func parseTOTPSeed(input string) (string, error) {
+	seed := strings.TrimSpace(input)
+
+	if strings.HasPrefix(seed, "otpauth://") {
+		uri, err := url.Parse(seed)
+		if err != nil || uri.Host != "totp" {
+			return "", fmt.Errorf("invalid MFA seed: expected an otpauth://totp/ URI")
+		}
+		query := uri.Query()
+		if algorithm := query.Get("algorithm"); algorithm != "" && !strings.EqualFold(algorithm, "SHA1") {
+			return "", fmt.Errorf("unsupported TOTP algorithm %s (AWS uses SHA1)", algorithm)
+		}
+		if digits := query.Get("digits"); digits != "" && digits != "6" {
+			return "", fmt.Errorf("unsupported TOTP length of %s digits (AWS uses 6)", digits)
+		}
+		if period := query.Get("period"); period != "" && period != "30" {
+			return "", fmt.Errorf("unsupported TOTP period of %ss (AWS uses 30s)", period)
+		}
+		seed = query.Get("secret")
+	}
+
+	// Authenticator apps show the secret in groups and without padding
+	seed = strings.ToUpper(strings.NewReplacer(" ", "", "-", "", "=", "").Replace(seed))
+	if seed == "" {
+		return "", fmt.Errorf("MFA seed cannot be empty")
+	}
+
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(seed)
+	if err != nil || len(key) < 10 {
+		return "", fmt.Errorf("invalid MFA seed: expected the base32 secret key of a virtual MFA device")
+	}
+
+	return seed, nil
+}
+
+// totpCode computes the RFC 6238 code of a seed at time t
+func totpCode(seed string, t time.Time) (string, error) {
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(seed)
+	if err != nil {
+		return "", fmt.Errorf("invalid MFA seed in vault: %w", err)
+	}
+
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(t.Unix()/int64(totpPeriod/time.Second)))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+
+	// Dynamic truncation (RFC 4226 section 5.3)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	return fmt.Sprintf("%06d", value%1000000), nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTOTPCode(t *testing.T) {
+	// RFC 6238 appendix B SHA1 vectors, truncated to 6 digits
+	seed := "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ" // "12345678901234567890"
+
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+	for _, tt := range tests {
+		got, err := totpCode(seed, time.Unix(tt.unix, 0))
+		if err != nil || got != tt.want {
+			t.Errorf("totpCode at %d = %q, %v; want %q", tt.unix, got, err, tt.want)
+		}
+	}
+}
+
+func TestParseTOTPSeed(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    string
+		wantErr bool
+	}{
+		{input: "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ", want: "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"},
+		{input: " gezd gnbv gy3t qojq gezd gnbv gy3t qojq\n", want: "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"},
+		{input: "otpauth://totp/Amazon%20Web%20Services:alice@123456789012?secret=GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ&issuer=Amazon%20Web%20Services", want: "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"},
+		{input: "otpauth://totp/x?secret=GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ&digits=8", wantErr: true},
+		{input: "otpauth://hotp/x?secret=GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ", wantErr: true},
+		{input: "", wantErr: true},
+		{input: "not base32!", wantErr: true},
+		{input: "GEZDGNBV", wantErr: true}, // too short to be a real secret
+	}
+	for _, tt := range tests {
+		got, err := parseTOTPSeed(tt.input)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseTOTPSeed(%q) = %q, want error", tt.input, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("parseTOTPSeed(%q) = %q, %v; want %q", tt.input, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"syscall"
+
+	"golang.org/x/term"
+)
+
+// openTTY opens the controlling terminal, so prompts keep working when
+// stdin and stdout are redirected (e.g. when run as a credential_process)
+func openTTY() (*os.File, error) {
+	return os.OpenFile("/dev/tty", os.O_RDWR, 0)
+}
+
+// readHiddenInput prompts on the terminal and reads input without echo.
+// Without a controlling terminal it prompts on stderr and reads stdin.
+// Caller is responsible for clearing the bytes after use
+func readHiddenInput(prompt string) ([]byte, error) {
+	tty, err := openTTY()
+	if err != nil {
+		fmt.Fprint(os.Stderr, prompt)
+		input, err := term.ReadPassword(int(syscall.Stdin))
+		fmt.Fprintln(os.Stderr)
+		return input, err
+	}
+	defer tty.Close()
+
+	fmt.Fprint(tty, prompt)
+	input, err := term.ReadPassword(int(tty.Fd()))
+	fmt.Fprintln(tty)
+	return input, err
+}
+
+// readLineInput prompts on the terminal and reads a single line of input.
+// Without a controlling terminal it prompts on stderr and reads stdin.
+func readLineInput(prompt string) (string, error) {
+	var in io.Reader = os.Stdin
+	var out io.Writer = os.Stderr
+
+	if tty, err := openTTY(); err == nil {
+		defer tty.Close()
+		in, out = tty, tty
+	}
+
+	fmt.Fprint(out, prompt)
+	line, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && line == "" {
+		return "", fmt.Errorf("failed to read input: %w", err)
+	}
+
+	return strings.TrimSpace(line), nil
+}
@@ -2,6 +2,8 @@ package main
 
 import (
 	"fmt"
+	"regexp"
+	"strconv"
 	"strings"
 )
 
@@ -42,3 +44,88 @@ func validateAccessKey(key string) error {
 
 	return nil
 }
+
+var (
+	regionPattern          = regexp.MustCompile(`^[a-z]{2}(-gov|-iso[a-z]?)?-[a-z]+-\d+$`)
+	mfaSerialPattern       = regexp.MustCompile(`^arn:aws[a-z-]*:iam::\d{12}:mfa/[\w+=,.@/-]+$`)
+	hardwareMFAPattern     = regexp.MustCompile(`^[\w+=/:,.@-]{9,256}$`)
+	roleARNPattern         = regexp.MustCompile(`^arn:aws[a-z-]*:iam::\d{12}:role/[\w+=,.@/-]+$`)
+	roleSessionNamePattern = regexp.MustCompile(`^[\w+=,.@-]{2,64}$`)
+	accountIDPattern       = regexp.MustCompile(`^\d{12}$`)
+	configKeyPattern       = regexp.MustCompile(`^[A-Za-z0-9_-]+(\.[A-Za-z0-9_-]+)?$`)
+	consoleServicePattern  = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+)
+
+// validateConfigKey validates a ~/.aws/config key; sub-properties are written as "parent.child"
+func validateConfigKey(key string) error {
+	if !configKeyPattern.MatchString(key) {
+		return fmt.Errorf("invalid config key: %q (letters, digits, _ and -, with an optional .sub-key)", key)
+	}
+	return nil
+}
+
+// validateConfigValue validates a ~/.aws/config setting before it is written.
+// Keys caws does not know are accepted as long as the value fits on one line
+func validateConfigValue(key, value string) error {
+	if value == "" {
+		return fmt.Errorf("value for %s cannot be empty", key)
+	}
+	if strings.ContainsAny(value, "\n\r") {
+		return fmt.Errorf("value for %s cannot contain line breaks", key)
+	}
+
+	switch key {
+	case "region", "sso_region":
+		if !regionPattern.MatchString(value) {
+			return fmt.Errorf("invalid %s: %s (expected a region such as us-east-1)", key, value)
+		}
+	case "mfa_serial":
+		// Virtual devices are identified by ARN, hardware tokens by serial number
+		valid := mfaSerialPattern.MatchString(value)
+		if !strings.HasPrefix(value, "arn:") {
+			valid = hardwareMFAPattern.MatchString(value)
+		}
+		if !valid {
+			return fmt.Errorf("invalid mfa_serial: %s (expected arn:aws:iam::123456789012:mfa/device-name or a hardware token serial number)", value)
+		}
+	case "role_arn":
+		if !roleARNPattern.MatchString(value) {
+			return fmt.Errorf("invalid role_arn: %s (expected arn:aws:iam::123456789012:role/role-name)", value)
+		}
+	case "source_profile":
+		return validateProfileName(value)
+	case "role_session_name":
+		if !roleSessionNamePattern.MatchString(value) {
+			return fmt.Errorf("invalid role_session_name: %s (2-64 letters, digits and +=,.@_-)", value)
+		}
+	case "duration_seconds":
+		// Role profiles are further limited to maxRoleDuration when used
+		seconds, err := strconv.Atoi(value)
+		if err != nil || seconds < minSessionDuration || seconds > maxSessionDuration {
+			return fmt.Errorf("invalid duration_seconds: %s (expected %d-%d)", value, minSessionDuration, maxSessionDuration)
+		}
+	case "console_duration_seconds":
+		seconds, err := strconv.Atoi(value)
+		if err != nil || seconds < minFederationDuration || seconds > maxFederationDuration {
+			return fmt.Errorf("invalid console_duration_seconds: %s (expected %d-%d)", value, minFederationDuration, maxFederationDuration)
+		}
+	case "console_service":
+		if !consoleServicePattern.MatchString(value) {
+			return fmt.Errorf("invalid console_service: %s (expected a name such as ec2 or cloudwatch)", value)
+		}
+	case "console_path":
+		if !strings.HasPrefix(value, "/") || strings.HasPrefix(value, "//") {
+			return fmt.Errorf("invalid console_path: %s (must start with a single /)", value)
+		}
+	case "sso_account_id":
+		if !accountIDPattern.MatchString(value) {
+			return fmt.Errorf("invalid sso_account_id: %s (expected a 12-digit account ID)", value)
+		}
+	case "sso_start_url":
+		if !strings.HasPrefix(value, "https://") {
+			return fmt.Errorf("invalid sso_start_url: %s (expected an https:// URL)", value)
+		}
+	}
+
+	return nil
+}
@@ -95,3 +95,74 @@ func TestValidateAccessKeyErrorMessages(t *testing.T) {
 		t.Errorf("expected 'should start with' in error, got: %v", err)
 	}
 }
+
+func TestValidateConfigValue(t *testing.T) {
+	tests := []struct {
+		key       string
+		value     string
+		wantError bool
+	}{
+		{"region", "us-east-1", false},
+		{"region", "us-gov-west-1", false},
+		{"region", "useast1", true},
+		{"region", "US-EAST-1", true},
+		{"sso_region", "eu-central-1", false},
+		{"mfa_serial", "arn:aws:iam::123456789012:mfa/alice", false},
+		{"mfa_serial", "arn:aws-us-gov:iam::123456789012:mfa/alice", false},
+		{"mfa_serial", "arn:aws:iam::123456789012:user/alice", true},
+		{"mfa_serial", "arn:aws:iam::1234:mfa/alice", true},
+		{"mfa_serial", "GAHT12345678", false},
+		{"mfa_serial", "GAHT1", true},
+		{"mfa_serial", "GAHT 12345678", true},
+		{"role_arn", "arn:aws:iam::123456789012:role/Admin", false},
+		{"role_arn", "arn:aws:iam::123456789012:role/path/Admin", false},
+		{"role_arn", "arn:aws:iam::123456789012:mfa/Admin", true},
+		{"source_profile", "base", false},
+		{"source_profile", "../base", true},
+		{"role_session_name", "alice", false},
+		{"role_session_name", "alice smith", true},
+		{"duration_seconds", "3600", false},
+		{"duration_seconds", "60", true},
+		{"duration_seconds", "soon", true},
+		{"duration_seconds", "129600", false},
+		{"duration_seconds", "129601", true},
+		{"console_duration_seconds", "43200", false},
+		{"console_duration_seconds", "86400", true},
+		{"console_service", "cloudwatch", false},
+		{"console_service", "EC2 Dashboard", true},
+		{"console_path", "/cloudwatch/home#logs:", false},
+		{"console_path", "//evil.example.com/", true},
+		{"sso_account_id", "123456789012", false},
+		{"sso_account_id", "12345", true},
+		{"sso_start_url", "https://corp.awsapps.com/start", false},
+		{"sso_start_url", "http://corp.awsapps.com/start", true},
+		{"output", "json", false},
+		{"output", "", true},
+		{"output", "json\nregion = x", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.key+"="+tt.value, func(t *testing.T) {
+			err := validateConfigValue(tt.key, tt.value)
+			if tt.wantError && err == nil {
+				t.Errorf("expected error for %s=%q, got nil", tt.key, tt.value)
+			}
+			if !tt.wantError && err != nil {
+				t.Errorf("expected no error for %s=%q, got: %v", tt.key, tt.value, err)
+			}
+		})
+	}
+}
+
+func TestValidateConfigKey(t *testing.T) {
+	for _, key := range []string{"region", "s3.max_concurrent_requests", "cli-pager"} {
+		if err := validateConfigKey(key); err != nil {
+			t.Errorf("expected %q to be valid, got: %v", key, err)
+		}
+	}
+	for _, key := range []string{"", "a=b", "[profile x]", "a.b.c", "two words"} {
+		if err := validateConfigKey(key); err == nil {
+			t.Errorf("expected %q to be invalid", key)
+		}
+	}
+}
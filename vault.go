@@ -1,13 +1,12 @@
 package main
 
 import (
+	"bytes"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
-	"syscall"
-
-	"golang.org/x/term"
 )
 
 // readPassword prompts for a password or uses CAWS_PASSWORD in test mode
@@ -19,9 +18,7 @@ func readPassword(prompt string) (string, error) {
 	}
 
 	// Normal interactive prompt
-	fmt.Print(prompt)
-	passwordBytes, err := term.ReadPassword(int(syscall.Stdin))
-	fmt.Println()
+	passwordBytes, err := readHiddenInput(prompt)
 	if err != nil {
 		return "", fmt.Errorf("failed to read password: %w", err)
 	}
@@ -43,9 +40,7 @@ func readPasswordBytes(prompt string) ([]byte, error) {
 	}
 
 	// Normal interactive prompt
-	fmt.Print(prompt)
-	passwordBytes, err := term.ReadPassword(int(syscall.Stdin))
-	fmt.Println()
+	passwordBytes, err := readHiddenInput(prompt)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read password: %w", err)
 	}
@@ -53,6 +48,36 @@ func readPasswordBytes(prompt string) ([]byte, error) {
 	return passwordBytes, nil
 }
 
+// readNewPassword prompts for a new password twice and checks that both match.
+// In test mode CAWS_NEW_PASSWORD, if set, takes precedence over CAWS_PASSWORD.
+// Caller is responsible for clearing the bytes after use
+func readNewPassword(prompt, confirmPrompt string) ([]byte, error) {
+	if testPass := os.Getenv("CAWS_NEW_PASSWORD"); testPass != "" {
+		fmt.Fprintf(os.Stderr, "%s[test mode]\n", prompt)
+		fmt.Fprintf(os.Stderr, "%s[test mode]\n", confirmPrompt)
+		return []byte(testPass), nil
+	}
+
+	password1Bytes, err := readPasswordBytes(prompt)
+	if err != nil {
+		return nil, err
+	}
+
+	password2Bytes, err := readPasswordBytes(confirmPrompt)
+	if err != nil {
+		clearBytes(password1Bytes)
+		return nil, err
+	}
+	defer clearBytes(password2Bytes)
+
+	if !bytes.Equal(password1Bytes, password2Bytes) {
+		clearBytes(password1Bytes)
+		return nil, fmt.Errorf("passwords do not match")
+	}
+
+	return password1Bytes, nil
+}
+
 // clearBytes zeros out a byte slice
 func clearBytes(b []byte) {
 	for i := range b {
@@ -67,20 +92,31 @@ type ProfileInfo struct {
 	MFASerial string
 }
 
-// CredentialStore defines the interface for credential storage backends
+// CredentialStore defines the interface for credential storage backends.
+// Read operations run concurrently with each other (shared vault lock);
+// mutating operations wait until they have the vault to themselves
+// (exclusive vault lock). Locks are only held for the operation itself
 type CredentialStore interface {
+	// Read operations
 	GetCredentials(profile string) (*AWSCredentials, error)
-	CreateCredentials(profile, accessKey, secretKey string) error
 	ListProfiles() ([]ProfileInfo, error)
+	GetSSOToken(session string) (*SSOToken, error) // nil if none is stored
+
+	// Mutating operations
+	CreateCredentials(profile, accessKey, secretKey string) error
 	RemoveProfile(profile string) error
+	SetMFASeed(profile, seed string) error // an empty seed removes it
+	StoreSSOToken(session string, token *SSOToken) error
+
 	Close() error
 }
 
 // VaultClient handles interactions with the encrypted vault
 type VaultClient struct {
 	vaultPath string
-	password  string
-	lockFile  *os.File
+	key       []byte     // Argon2id-derived vault key
+	salt      []byte     // Salt the key was derived with
+	kdf       *KDFParams // KDF header to write back (nil for v1 vaults)
 }
 
 // Ensure VaultClient implements CredentialStore
@@ -95,52 +131,40 @@ func NewVaultClient() (*VaultClient, error) {
 		return nil, fmt.Errorf("vault not found at %s\nRun 'caws init' to create a new vault", vaultPath)
 	}
 
-	// Acquire lock on vault
-	lockFile, err := acquireVaultLock(vaultPath)
+	// Prompt for password
+	passwordBytes, err := readPasswordBytes("Enter vault password: ")
 	if err != nil {
 		return nil, err
 	}
+	// Clear password bytes from memory once the key is derived
+	defer clearBytes(passwordBytes)
 
-	// Prompt for password
-	passwordBytes, err := readPasswordBytes("Enter vault password: ")
+	// Verifying the password is a read; other readers may go on meanwhile
+	lockFile, err := acquireVaultLock(vaultPath, lockShared)
 	if err != nil {
-		lockFile.Close()
-		os.Remove(vaultPath + ".lock")
 		return nil, err
 	}
+	defer releaseVaultLock(lockFile)
 
-	password := string(passwordBytes)
-	// Clear password bytes from memory after converting to string
-	defer clearBytes(passwordBytes)
-
-	// Verify password by attempting to decrypt
-	if err := verifyPassword(vaultPath, password); err != nil {
-		lockFile.Close()
-		os.Remove(vaultPath + ".lock")
+	// Derive the key once and verify it by decrypting
+	client, err := unlockVault(vaultPath, string(passwordBytes))
+	if err != nil {
 		return nil, fmt.Errorf("incorrect password or corrupted vault")
 	}
 
-	return &VaultClient{
-		vaultPath: vaultPath,
-		password:  password,
-		lockFile:  lockFile,
-	}, nil
+	return client, nil
 }
 
 // Close implements the CredentialStore interface
-// Releases the vault lock file
+// Clears the key from memory
 func (v *VaultClient) Close() error {
-	if v.lockFile != nil {
-		lockPath := v.lockFile.Name()
-		v.lockFile.Close()
-		os.Remove(lockPath)
-	}
+	clearBytes(v.key)
 	return nil
 }
 
 // GetCredentials retrieves AWS credentials for a profile
 func (v *VaultClient) GetCredentials(profile string) (*AWSCredentials, error) {
-	data, err := v.loadVault()
+	data, err := v.read()
 	if err != nil {
 		return nil, err
 	}
@@ -153,34 +177,73 @@ func (v *VaultClient) GetCredentials(profile string) (*AWSCredentials, error) {
 	return &AWSCredentials{
 		AccessKeyID:     profileData.AccessKey,
 		SecretAccessKey: profileData.SecretKey,
+		MFASeed:         profileData.MFASeed,
 		// Region and MFASerial will be loaded from ~/.aws/config instead
 	}, nil
 }
 
 // CreateCredentials stores AWS credentials for a profile
 func (v *VaultClient) CreateCredentials(profile string, accessKey, secretKey string) error {
-	data, err := v.loadVault()
+	return v.update(func(data *VaultData) error {
+		// Initialize profiles map if needed
+		if data.Profiles == nil {
+			data.Profiles = make(map[string]ProfileData)
+		}
+
+		// Add or update profile, keeping its MFA seed
+		profileData := data.Profiles[profile]
+		profileData.AccessKey = accessKey
+		profileData.SecretKey = secretKey
+		data.Profiles[profile] = profileData
+
+		return nil
+	})
+}
+
+// SetMFASeed stores the TOTP secret of a profile's virtual MFA device
+func (v *VaultClient) SetMFASeed(profile, seed string) error {
+	return v.update(func(data *VaultData) error {
+		profileData, exists := data.Profiles[profile]
+		if !exists {
+			return fmt.Errorf("profile '%s' not found in vault", profile)
+		}
+
+		profileData.MFASeed = seed
+		data.Profiles[profile] = profileData
+
+		return nil
+	})
+}
+
+// GetSSOToken returns the stored IAM Identity Center token of a session, or nil
+func (v *VaultClient) GetSSOToken(session string) (*SSOToken, error) {
+	data, err := v.read()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	// Initialize profiles map if needed
-	if data.Profiles == nil {
-		data.Profiles = make(map[string]ProfileData)
+	token, exists := data.SSOTokens[session]
+	if !exists {
+		return nil, nil
 	}
+	return &token, nil
+}
 
-	// Add or update profile
-	data.Profiles[profile] = ProfileData{
-		AccessKey: accessKey,
-		SecretKey: secretKey,
-	}
+// StoreSSOToken stores the IAM Identity Center token of a session
+func (v *VaultClient) StoreSSOToken(session string, token *SSOToken) error {
+	return v.update(func(data *VaultData) error {
+		if data.SSOTokens == nil {
+			data.SSOTokens = make(map[string]SSOToken)
+		}
+		data.SSOTokens[session] = *token
 
-	return v.saveVault(data)
+		return nil
+	})
 }
 
 // ListProfiles returns all profiles stored in the vault
 func (v *VaultClient) ListProfiles() ([]ProfileInfo, error) {
-	data, err := v.loadVault()
+	data, err := v.read()
 	if err != nil {
 		return nil, err
 	}
@@ -209,33 +272,67 @@ func (v *VaultClient) ListProfiles() ([]ProfileInfo, error) {
 
 // RemoveProfile removes a profile from the vault
 func (v *VaultClient) RemoveProfile(profile string) error {
-	data, err := v.loadVault()
+	return v.update(func(data *VaultData) error {
+		if _, exists := data.Profiles[profile]; !exists {
+			return fmt.Errorf("profile '%s' not found", profile)
+		}
+
+		delete(data.Profiles, profile)
+
+		return nil
+	})
+}
+
+// read loads the vault under a shared lock, for read operations
+func (v *VaultClient) read() (*VaultData, error) {
+	lockFile, err := acquireVaultLock(v.vaultPath, lockShared)
+	if err != nil {
+		return nil, err
+	}
+	defer releaseVaultLock(lockFile)
+
+	return v.loadVault()
+}
+
+// update applies a change to the vault under an exclusive lock, so that
+// concurrent mutations never overwrite each other. Nothing is written if
+// change fails
+func (v *VaultClient) update(change func(data *VaultData) error) error {
+	lockFile, err := acquireVaultLock(v.vaultPath, lockExclusive)
 	if err != nil {
 		return err
 	}
+	defer releaseVaultLock(lockFile)
 
-	if _, exists := data.Profiles[profile]; !exists {
-		return fmt.Errorf("profile '%s' not found", profile)
+	data, err := v.loadVault()
+	if err != nil {
+		return err
 	}
 
-	delete(data.Profiles, profile)
+	if err := change(data); err != nil {
+		return err
+	}
 
 	return v.saveVault(data)
 }
 
 // loadVault reads and decrypts the vault
 func (v *VaultClient) loadVault() (*VaultData, error) {
-	fileData, err := os.ReadFile(v.vaultPath)
+	vaultFile, err := readVaultFile(v.vaultPath)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read vault file: %w", err)
+		return nil, err
 	}
 
-	var vaultFile VaultFile
-	if err := json.Unmarshal(fileData, &vaultFile); err != nil {
-		return nil, fmt.Errorf("failed to parse vault file: %w", err)
+	// The key only fits the salt it was derived with
+	salt, err := base64.StdEncoding.DecodeString(vaultFile.Salt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode salt: %w", err)
+	}
+	if !bytes.Equal(salt, v.salt) {
+		return nil, fmt.Errorf("vault was re-encrypted since it was unlocked")
 	}
 
-	data, err := decryptVault(v.password, &vaultFile)
+	data, err := decryptVaultWithKey(v.key, vaultFile)
 	if err != nil {
 		return nil, err
 	}
@@ -243,25 +340,45 @@ func (v *VaultClient) loadVault() (*VaultData, error) {
 	return data, nil
 }
 
-// saveVault encrypts and writes the vault
+// saveVault encrypts and writes the vault, keeping its format version
 func (v *VaultClient) saveVault(data *VaultData) error {
-	vaultFile, err := encryptVault(v.password, data)
+	vaultFile, err := encryptVaultWithKey(v.key, v.salt, v.kdf, data)
 	if err != nil {
 		return fmt.Errorf("failed to encrypt vault: %w", err)
 	}
 
+	return writeVaultFile(v.vaultPath, vaultFile)
+}
+
+// readVaultFile reads and parses the vault file
+func readVaultFile(vaultPath string) (*VaultFile, error) {
+	fileData, err := os.ReadFile(vaultPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read vault file: %w", err)
+	}
+
+	var vaultFile VaultFile
+	if err := json.Unmarshal(fileData, &vaultFile); err != nil {
+		return nil, fmt.Errorf("failed to parse vault file: %w", err)
+	}
+
+	return &vaultFile, nil
+}
+
+// writeVaultFile atomically replaces the vault file
+func writeVaultFile(vaultPath string, vaultFile *VaultFile) error {
 	fileData, err := json.MarshalIndent(vaultFile, "", "  ")
 	if err != nil {
 		return fmt.Errorf("failed to marshal vault file: %w", err)
 	}
 
 	// Write to temp file first, then rename (atomic write)
-	tempPath := v.vaultPath + ".tmp"
+	tempPath := vaultPath + ".tmp"
 	if err := os.WriteFile(tempPath, fileData, 0600); err != nil {
 		return fmt.Errorf("failed to write vault file: %w", err)
 	}
 
-	if err := os.Rename(tempPath, v.vaultPath); err != nil {
+	if err := os.Rename(tempPath, vaultPath); err != nil {
 		os.Remove(tempPath) // Clean up temp file
 		return fmt.Errorf("failed to replace vault file: %w", err)
 	}
@@ -269,20 +386,110 @@ func (v *VaultClient) saveVault(data *VaultData) error {
 	return nil
 }
 
-// verifyPassword checks if a password can decrypt the vault
-func verifyPassword(vaultPath, password string) error {
-	fileData, err := os.ReadFile(vaultPath)
+// unlockVault derives the vault key from a password and verifies it by
+// decrypting the vault. The returned client does not hold the vault lock
+func unlockVault(vaultPath, password string) (*VaultClient, error) {
+	vaultFile, err := readVaultFile(vaultPath)
+	if err != nil {
+		return nil, err
+	}
+
+	kdf, err := vaultKDFParams(vaultFile)
+	if err != nil {
+		return nil, err
+	}
+
+	salt, err := base64.StdEncoding.DecodeString(vaultFile.Salt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode salt: %w", err)
+	}
+
+	key := kdf.deriveKey(password, salt)
+	if _, err := decryptVaultWithKey(key, vaultFile); err != nil {
+		clearBytes(key)
+		return nil, err
+	}
+
+	return &VaultClient{
+		vaultPath: vaultPath,
+		key:       key,
+		salt:      salt,
+		kdf:       vaultFile.KDF,
+	}, nil
+}
+
+// openVaultWithKey verifies an already derived key against the vault, e.g.
+// one handed over to the agent. The returned client does not hold the vault lock
+func openVaultWithKey(vaultPath string, key, salt []byte) (*VaultClient, error) {
+	client := &VaultClient{
+		vaultPath: vaultPath,
+		key:       key,
+		salt:      salt,
+	}
+
+	vaultFile, err := readVaultFile(vaultPath)
+	if err != nil {
+		return nil, err
+	}
+	client.kdf = vaultFile.KDF
+
+	if _, err := client.loadVault(); err != nil {
+		return nil, err
+	}
+
+	return client, nil
+}
+
+// UpgradeVault rewrites the vault in the current format with the default
+// KDF parameters and a fresh salt, replacing the file in one atomic rename
+func UpgradeVault() error {
+	vaultPath := getVaultPath()
+
+	if _, err := os.Stat(vaultPath); os.IsNotExist(err) {
+		return fmt.Errorf("vault not found at %s\nRun 'caws init' to create a new vault", vaultPath)
+	}
+
+	lockFile, err := acquireVaultLock(vaultPath, lockExclusive)
 	if err != nil {
 		return err
 	}
+	defer releaseVaultLock(lockFile)
 
-	var vaultFile VaultFile
-	if err := json.Unmarshal(fileData, &vaultFile); err != nil {
+	vaultFile, err := readVaultFile(vaultPath)
+	if err != nil {
+		return err
+	}
+
+	if _, err := vaultKDFParams(vaultFile); err != nil {
+		return err
+	}
+	if vaultFile.Version == vaultVersion && *vaultFile.KDF == defaultKDFParams() {
+		fmt.Printf("Vault is already at version %d\n", vaultVersion)
+		return nil
+	}
+
+	passwordBytes, err := readPasswordBytes("Enter vault password: ")
+	if err != nil {
+		return err
+	}
+	defer clearBytes(passwordBytes)
+
+	data, err := decryptVault(string(passwordBytes), vaultFile)
+	if err != nil {
+		return fmt.Errorf("incorrect password or corrupted vault")
+	}
+
+	upgraded, err := encryptVault(string(passwordBytes), data)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt vault: %w", err)
+	}
+
+	if err := writeVaultFile(vaultPath, upgraded); err != nil {
 		return err
 	}
 
-	_, err = decryptVault(password, &vaultFile)
-	return err
+	fmt.Printf("✓ Vault upgraded from version %d to version %d\n", vaultFile.Version, vaultVersion)
+	return nil
 }
 
 // getVaultPath returns the path to the vault file
@@ -307,24 +514,11 @@ func InitVault() error {
 	}
 
 	// Prompt for password twice
-	password1Bytes, err := readPasswordBytes("Enter master password: ")
-	if err != nil {
-		return err
-	}
-	defer clearBytes(password1Bytes)
-
-	password2Bytes, err := readPasswordBytes("Confirm password: ")
+	passwordBytes, err := readNewPassword("Enter master password: ", "Confirm password: ")
 	if err != nil {
 		return err
 	}
-	defer clearBytes(password2Bytes)
-
-	password1 := string(password1Bytes)
-	password2 := string(password2Bytes)
-
-	if password1 != password2 {
-		return fmt.Errorf("passwords do not match")
-	}
+	defer clearBytes(passwordBytes)
 
 	// Create vault directory
 	vaultDir := filepath.Dir(vaultPath)
@@ -337,7 +531,7 @@ func InitVault() error {
 		Profiles: make(map[string]ProfileData),
 	}
 
-	vaultFile, err := encryptVault(password1, emptyData)
+	vaultFile, err := encryptVault(string(passwordBytes), emptyData)
 	if err != nil {
 		return fmt.Errorf("failed to encrypt vault: %w", err)
 	}
@@ -355,22 +549,55 @@ func InitVault() error {
 	return nil
 }
 
-// acquireVaultLock creates an exclusive lock file for the vault
-func acquireVaultLock(vaultPath string) (*os.File, error) {
-	lockPath := vaultPath + ".lock"
+// ChangePassword re-encrypts the vault under a new master password with a
+// fresh salt. The vault stays locked throughout and is replaced atomically
+func ChangePassword() error {
+	vaultPath := getVaultPath()
+
+	if _, err := os.Stat(vaultPath); os.IsNotExist(err) {
+		return fmt.Errorf("vault not found at %s\nRun 'caws init' to create a new vault", vaultPath)
+	}
 
-	// Try to create lock file exclusively
-	// O_CREATE|O_EXCL ensures atomic creation
-	lockFile, err := os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	lockFile, err := acquireVaultLock(vaultPath, lockExclusive)
 	if err != nil {
-		if os.IsExist(err) {
-			return nil, fmt.Errorf("vault is locked by another process (lock file: %s)", lockPath)
-		}
-		return nil, fmt.Errorf("failed to create lock file: %w", err)
+		return err
+	}
+	defer releaseVaultLock(lockFile)
+
+	vaultFile, err := readVaultFile(vaultPath)
+	if err != nil {
+		return err
+	}
+
+	currentBytes, err := readPasswordBytes("Enter current password: ")
+	if err != nil {
+		return err
+	}
+	data, err := decryptVault(string(currentBytes), vaultFile)
+	clearBytes(currentBytes)
+	if err != nil {
+		return fmt.Errorf("incorrect password or corrupted vault")
+	}
+
+	newBytes, err := readNewPassword("Enter new password: ", "Confirm new password: ")
+	if err != nil {
+		return err
+	}
+	defer clearBytes(newBytes)
+
+	if len(newBytes) == 0 {
+		return fmt.Errorf("new password cannot be empty")
+	}
+
+	newVaultFile, err := encryptVault(string(newBytes), data)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt vault: %w", err)
 	}
 
-	// Write PID to lock file for debugging
-	fmt.Fprintf(lockFile, "%d\n", os.Getpid())
+	if err := writeVaultFile(vaultPath, newVaultFile); err != nil {
+		return err
+	}
 
-	return lockFile, nil
+	fmt.Println("✓ Vault password changed")
+	return nil
 }
@@ -1,8 +1,11 @@
 package main
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
+	"syscall"
 )
 
 // getXDGDataHome returns the XDG data directory
@@ -34,3 +37,56 @@ func getXDGCacheHome() string {
 
 	return filepath.Join(homeDir, ".cache")
 }
+
+// getXDGRuntimeDir returns the XDG runtime directory
+// Defaults to a per-user directory under the system temp dir if XDG_RUNTIME_DIR is not set
+func getXDGRuntimeDir() string {
+	if xdgRuntime := os.Getenv("XDG_RUNTIME_DIR"); xdgRuntime != "" {
+		return xdgRuntime
+	}
+
+	return filepath.Join(os.TempDir(), fmt.Sprintf("caws-%d", os.Getuid()))
+}
+
+// ensureRuntimeDir creates a directory for sockets and keys below
+// getXDGRuntimeDir, refusing one that other users could tamper with
+func ensureRuntimeDir(dir string) error {
+	runtimeDir := getXDGRuntimeDir()
+	if !strings.HasPrefix(dir, runtimeDir+string(filepath.Separator)) {
+		// Elsewhere, e.g. CAWS_TEST_DIR, the directory is the caller's choice
+		return os.MkdirAll(dir, 0700)
+	}
+
+	// Without XDG_RUNTIME_DIR the base directory lies in the shared temp dir,
+	// where anyone could have created it first
+	if os.Getenv("XDG_RUNTIME_DIR") == "" {
+		if err := ensurePrivateDir(runtimeDir); err != nil {
+			return err
+		}
+	}
+	return ensurePrivateDir(dir)
+}
+
+// ensurePrivateDir creates dir if needed and checks that it is a real
+// directory owned by the current user with mode 0700
+func ensurePrivateDir(dir string) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); !ok || int(stat.Uid) != os.Getuid() {
+		return fmt.Errorf("%s is not owned by the current user", dir)
+	}
+	if info.Mode().Perm() != 0700 {
+		return fmt.Errorf("%s has mode %04o, expected 0700", dir, info.Mode().Perm())
+	}
+
+	return nil
+}
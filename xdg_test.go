@@ -0,0 +1,67 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEnsurePrivateDir(t *testing.T) {
+	base := t.TempDir()
+
+	created := filepath.Join(base, "created", "caws")
+	if err := ensurePrivateDir(created); err != nil {
+		t.Fatalf("new directory refused: %v", err)
+	}
+
+	open := filepath.Join(base, "open")
+	if err := os.Mkdir(open, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(open, 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := ensurePrivateDir(open); err == nil {
+		t.Error("directory writable by others accepted")
+	}
+
+	link := filepath.Join(base, "link")
+	if err := os.Symlink(created, link); err != nil {
+		t.Fatal(err)
+	}
+	if err := ensurePrivateDir(link); err == nil {
+		t.Error("symlink accepted")
+	}
+
+	file := filepath.Join(base, "file")
+	if err := os.WriteFile(file, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ensurePrivateDir(file); err == nil {
+		t.Error("file accepted")
+	}
+}
+
+func TestEnsureRuntimeDirFallback(t *testing.T) {
+	t.Setenv("XDG_RUNTIME_DIR", "")
+	t.Setenv("TMPDIR", t.TempDir())
+
+	// Another user got to the shared fallback directory first
+	runtimeDir := getXDGRuntimeDir()
+	if err := os.Mkdir(runtimeDir, 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(runtimeDir, 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := ensureRuntimeDir(filepath.Join(runtimeDir, "caws")); err == nil {
+		t.Fatal("runtime directory writable by others accepted")
+	}
+
+	if err := os.Chmod(runtimeDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ensureRuntimeDir(filepath.Join(runtimeDir, "caws")); err != nil {
+		t.Fatalf("private runtime directory refused: %v", err)
+	}
+}